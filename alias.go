@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// aliasPollInterval is how often a served alias is checked for a new target.
+var aliasPollInterval = time.Second
+
+// maxAliasHistory bounds how many previous targets of an alias are remembered for rollbacks.
+const maxAliasHistory = 16
+
+// Alias is a stable name for an index, e.g. "standard" pointing at "standard-20261016". Rebuilds
+// go to a new index, which is then promoted to the alias, so clients never switch names. Aliases
+// are serialized to JSON in the aliases subdirectory of the index directory.
+type Alias struct {
+	// Name is the name of the alias, as provided by the user.
+	Name string `json:"name"`
+
+	// Index is the name of the index the alias currently points at.
+	Index string `json:"index"`
+
+	// History holds the indexes the alias previously pointed at, most recent last. Rollbacks pop
+	// from it.
+	History []string `json:"history,omitempty"`
+
+	// UpdatedAt is the timestamp of when the alias was last promoted or rolled back.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LoadAlias loads the Alias with the given name. If the alias doesn't exist, returns nil.
+func LoadAlias(name string) (*Alias, error) {
+	fp := aliasFilepath(name)
+	data, err := os.ReadFile(fp)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading alias file %q: %w", fp, err)
+	}
+	var alias Alias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return nil, fmt.Errorf("decoding alias file %q: %w", fp, err)
+	}
+	if alias.Name != name {
+		return nil, fmt.Errorf("alias name mismatch: expected %q, got %q", name, alias.Name)
+	}
+	return &alias, nil
+}
+
+// ListAliases returns every alias, sorted by name.
+func ListAliases() ([]*Alias, error) {
+	matches, err := filepath.Glob(aliasFilepath("*"))
+	if err != nil {
+		return nil, fmt.Errorf("listing alias files: %w", err)
+	}
+	aliases := make([]*Alias, 0, len(matches))
+	for _, fp := range matches {
+		alias, err := LoadAlias(strings.TrimSuffix(filepath.Base(fp), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
+
+// PromoteAlias points the named alias at an index, creating the alias if it doesn't exist. The
+// index it pointed at before is remembered, so the promotion can be rolled back.
+func PromoteAlias(name, index string) (*Alias, error) {
+	if existing, err := LoadIndex(name); err != nil {
+		return nil, fmt.Errorf("checking for index named %q: %w", name, err)
+	} else if existing != nil {
+		return nil, fmt.Errorf("%q is already the name of an index, it can't also be an alias", name)
+	}
+	target, err := LoadIndex(index)
+	if err != nil {
+		return nil, fmt.Errorf("loading index %q: %w", index, err)
+	} else if target == nil {
+		return nil, fmt.Errorf("index %q does not exist", index)
+	}
+
+	alias, err := LoadAlias(name)
+	if err != nil {
+		return nil, err
+	} else if alias == nil {
+		alias = &Alias{Name: name}
+	} else if alias.Index == index {
+		return alias, nil
+	}
+	if alias.Index != "" {
+		alias.History = append(alias.History, alias.Index)
+		if len(alias.History) > maxAliasHistory {
+			alias.History = alias.History[len(alias.History)-maxAliasHistory:]
+		}
+	}
+	alias.Index = index
+	alias.UpdatedAt = time.Now().UTC()
+	if err := alias.save(); err != nil {
+		return nil, err
+	}
+	return alias, nil
+}
+
+// RollbackAlias points the named alias back at the index it pointed at before its last promotion.
+func RollbackAlias(name string) (*Alias, error) {
+	alias, err := LoadAlias(name)
+	if err != nil {
+		return nil, err
+	} else if alias == nil {
+		return nil, fmt.Errorf("alias %q does not exist", name)
+	} else if len(alias.History) == 0 {
+		return nil, fmt.Errorf("alias %q has no previous index to roll back to", name)
+	}
+
+	previous := alias.History[len(alias.History)-1]
+	if index, err := LoadIndex(previous); err != nil {
+		return nil, fmt.Errorf("loading index %q: %w", previous, err)
+	} else if index == nil {
+		return nil, fmt.Errorf("previous index %q of alias %q no longer exists", previous, name)
+	}
+	alias.History = alias.History[:len(alias.History)-1]
+	alias.Index = previous
+	alias.UpdatedAt = time.Now().UTC()
+	if err := alias.save(); err != nil {
+		return nil, err
+	}
+	return alias, nil
+}
+
+// aliasesOf returns the names of the aliases which point at the named index, or could be rolled
+// back to it.
+func aliasesOf(index string) (current, previous []string, err error) {
+	aliases, err := ListAliases()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, alias := range aliases {
+		if alias.Index == index {
+			current = append(current, alias.Name)
+		} else if slices.Contains(alias.History, index) {
+			previous = append(previous, alias.Name)
+		}
+	}
+	return current, previous, nil
+}
+
+// save atomically replaces the alias file, so servers watching it never see a partial write.
+func (a *Alias) save() error {
+	fp := aliasFilepath(a.Name)
+	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+		return fmt.Errorf("creating alias directory: %w", err)
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("encoding alias %q: %w", a.Name, err)
+	}
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing alias file %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, fp); err != nil {
+		return fmt.Errorf("renaming alias file %q: %w", tmp, err)
+	}
+	return nil
+}
+
+func aliasFilepath(name string) string {
+	return filepath.Join(indexDir(), "aliases", name+".json")
+}
+
+// liveIndex is the index being served. When serving an alias, the index is swapped out whenever
+// the alias is promoted or rolled back, without interrupting in-flight searches.
+type liveIndex struct {
+	alias   string // empty if serving an index directly
+	current atomic.Pointer[Index]
+}
+
+// resolveIndex returns the index to serve for name, which is either an alias or an index.
+func resolveIndex(name string) (*liveIndex, error) {
+	live := &liveIndex{}
+	alias, err := LoadAlias(name)
+	if err != nil {
+		return nil, fmt.Errorf("loading alias %q: %w", name, err)
+	}
+	target := name
+	if alias != nil {
+		live.alias, target = alias.Name, alias.Index
+	}
+
+	index, err := LoadIndex(target)
+	if err != nil {
+		return nil, fmt.Errorf("loading index %q: %w", target, err)
+	} else if index == nil {
+		return nil, fmt.Errorf("index %q does not exist", target)
+	}
+	live.current.Store(index)
+	return live, nil
+}
+
+// Load returns the index currently being served.
+func (l *liveIndex) Load() *Index {
+	return l.current.Load()
+}
+
+// watch polls the alias being served until ctx is cancelled, swapping to its new index whenever
+// it changes. Does nothing if an index is being served directly.
+func (l *liveIndex) watch(ctx context.Context, interval time.Duration) {
+	if l.alias == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := l.refresh(); err != nil {
+			log.Printf("checking alias %q, still serving index %q: %v", l.alias, l.Load().Name, err)
+		}
+	}
+}
+
+// refresh swaps to the current target of the alias, if it changed.
+func (l *liveIndex) refresh() error {
+	alias, err := LoadAlias(l.alias)
+	if err != nil {
+		return err
+	} else if alias == nil {
+		return errors.New("alias no longer exists")
+	}
+	current := l.Load()
+	if alias.Index == current.Name {
+		return nil
+	}
+	index, err := LoadIndex(alias.Index)
+	if err != nil {
+		return fmt.Errorf("loading index %q: %w", alias.Index, err)
+	} else if index == nil {
+		return fmt.Errorf("index %q does not exist", alias.Index)
+	}
+	l.current.Store(index)
+	log.Printf(
+		"alias %q now points at index %q (namespace %q), swapped from index %q",
+		l.alias,
+		index.Name,
+		index.Namespace,
+		current.Name,
+	)
+	return nil
+}
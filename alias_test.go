@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAliases(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			serveFixtures(t)
+			backend, _ := newTestBackend(t, kind)
+			setFlag(t, flagSet, string(Standard))
+			setFlag(t, &aliasPollInterval, 10*time.Millisecond)
+			t.Chdir(t.TempDir())
+
+			ctx := t.Context()
+			for _, name := range []string{"cards-blue", "cards-green"} {
+				if err := buildIndex(ctx, backend, name); err != nil {
+					t.Fatalf("building index %q: %v", name, err)
+				}
+			}
+
+			if err := promoteAlias("cards=cards-blue"); err != nil {
+				t.Fatalf("promoting: %v", err)
+			}
+			for _, bad := range []string{"cards", "cards=", "=cards-blue", "cards=missing", "cards-green=cards-blue"} {
+				if err := promoteAlias(bad); err == nil {
+					t.Errorf("promoting %q succeeded, want an error", bad)
+				}
+			}
+			if _, err := RollbackAlias("cards"); err == nil {
+				t.Error("rolling back an alias without history succeeded")
+			}
+			if err := buildIndex(ctx, backend, "cards"); err == nil {
+				t.Error("building an index with the name of an alias succeeded")
+			}
+
+			// Serve the alias, then promote and roll back underneath the server.
+			addr := freeAddr(t)
+			setFlag(t, flagListen, addr)
+			serveCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			errc := make(chan error, 1)
+			go func() {
+				errc <- serveIndex(serveCtx, backend, "cards")
+			}()
+			base := "http://" + addr
+			waitForHealthy(t, base)
+
+			waitForIndex := func(want string) {
+				t.Helper()
+				deadline := time.Now().Add(5 * time.Second)
+				for {
+					var health map[string]string
+					getJSON(t, base+"/healthz", &health)
+					if health["index"] == want && health["alias"] == "cards" {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Fatalf("server is serving %v, want index %q for alias cards", health, want)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				var resp searchResponse
+				getJSON(t, base+"/search?q=landfall", &resp)
+				if resp.Index != want || len(resp.Results) == 0 {
+					t.Errorf("searching alias: got %d results from index %q, want index %q", len(resp.Results), resp.Index, want)
+				}
+			}
+			waitForIndex("cards-blue")
+
+			if err := promoteAlias("cards=cards-green"); err != nil {
+				t.Fatalf("promoting: %v", err)
+			}
+			waitForIndex("cards-green")
+
+			// The index being served can't be deleted out from under the alias.
+			if err := deleteIndex(ctx, backend, "cards-green"); err == nil {
+				t.Error("deleting the target of an alias succeeded")
+			}
+
+			if err := rollbackAlias("cards"); err != nil {
+				t.Fatalf("rolling back: %v", err)
+			}
+			waitForIndex("cards-blue")
+
+			alias, err := LoadAlias("cards")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if alias.Index != "cards-blue" || len(alias.History) != 0 {
+				t.Errorf("after rolling back: alias points at %q with history %v", alias.Index, alias.History)
+			}
+			if err := deleteIndex(ctx, backend, "cards-green"); err != nil {
+				t.Errorf("deleting an index no alias points at: %v", err)
+			}
+
+			cancel()
+			select {
+			case err := <-errc:
+				if err != nil {
+					t.Errorf("serving alias: %v", err)
+				}
+			case <-time.After(shutdownTimeout):
+				t.Error("server did not shut down")
+			}
+		})
+	}
+}
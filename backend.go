@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/turbopuffer/turbopuffer-go"
+)
+
+// ErrNamespaceNotFound is returned by a Backend when the requested namespace doesn't exist.
+var ErrNamespaceNotFound = errors.New("namespace not found")
+
+// ErrTransient is returned by a Backend when a request failed in a way which may succeed if it's
+// retried, e.g. because it was rate limited or hit a server error.
+var ErrTransient = errors.New("transient error")
+
+// Backend is the storage engine which holds the rows of an index, organized into namespaces.
+// Namespaces are created implicitly by the first write to them, like in turbopuffer.
+type Backend interface {
+	// Write upserts rows into a namespace, applying the given schema.
+	Write(ctx context.Context, namespace string, req WriteRequest) error
+
+	// Query ranks the rows in a namespace against a query, returning the best req.TopK rows.
+	Query(ctx context.Context, namespace string, req QueryRequest) ([]Row, error)
+
+	// Metadata returns information about a namespace, or ErrNamespaceNotFound.
+	Metadata(ctx context.Context, namespace string) (*NamespaceMetadata, error)
+
+	// DeleteAll deletes a namespace and all of its rows, or returns ErrNamespaceNotFound.
+	DeleteAll(ctx context.Context, namespace string) error
+
+	// Namespaces returns the names of all namespaces starting with prefix, in sorted order.
+	Namespaces(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Row is a single document in a namespace, keyed by attribute name. Every row has an "id", and
+// may have a "vector" attribute holding its embedding. Rows returned from a query additionally
+// carry their score (or, for vector queries, their distance) in the "$dist" attribute.
+type Row map[string]any
+
+// Schema describes the attributes of the rows in a namespace. We reuse turbopuffer's schema
+// definition for every backend, so that all backends index the same attributes the same way.
+type Schema map[string]turbopuffer.AttributeSchemaConfigParam
+
+// WriteRequest is a batch of changes to apply to a namespace. Deletes are applied after upserts.
+type WriteRequest struct {
+	Upserts []Row
+	Deletes []string
+	Schema  Schema
+}
+
+// QueryRequest describes a full-text or vector query. Rows are ranked by the weighted sum of the
+// BM25 scores of Text against each of the Fields. If Vector is set instead, rows are ranked by the
+// cosine distance between Vector and their "vector" attribute, nearest first. If neither is set,
+// every row matching Filters is a result, in order of ID.
+type QueryRequest struct {
+	Text              string
+	Fields            []FieldWeight
+	Vector            []float32
+	Filters           *Filter
+	TopK              int
+	IncludeAttributes []string
+}
+
+// FieldWeight is a full-text attribute and the weight given to its BM25 score when ranking.
+type FieldWeight struct {
+	Attribute string
+	Weight    float64
+}
+
+// Filter is a predicate over the attributes of a row, mirroring turbopuffer's filter expressions.
+// Logical operators (And, Or, Not) combine Filters, all others compare Attribute against Value.
+type Filter struct {
+	Op        FilterOp
+	Attribute string
+	Value     any
+	Filters   []Filter
+}
+
+// FilterOp is an enumeration of the supported filter operators. Values match turbopuffer's.
+type FilterOp string
+
+// List of supported filter operators.
+var (
+	OpAnd               FilterOp = "And"
+	OpOr                FilterOp = "Or"
+	OpNot               FilterOp = "Not"
+	OpEq                FilterOp = "Eq"
+	OpIn                FilterOp = "In"
+	OpLt                FilterOp = "Lt"
+	OpLte               FilterOp = "Lte"
+	OpGt                FilterOp = "Gt"
+	OpGte               FilterOp = "Gte"
+	OpContains          FilterOp = "Contains"
+	OpContainsAny       FilterOp = "ContainsAny"
+	OpContainsAllTokens FilterOp = "ContainsAllTokens"
+)
+
+func filterAnd(filters ...Filter) Filter {
+	return Filter{Op: OpAnd, Filters: filters}
+}
+
+func filterOr(filters ...Filter) Filter {
+	return Filter{Op: OpOr, Filters: filters}
+}
+
+func filterNot(filter Filter) Filter {
+	return Filter{Op: OpNot, Filters: []Filter{filter}}
+}
+
+func filterEq(attr string, value any) Filter {
+	return Filter{Op: OpEq, Attribute: attr, Value: value}
+}
+
+func filterIn[T any](attr string, values []T) Filter {
+	return Filter{Op: OpIn, Attribute: attr, Value: values}
+}
+
+func filterCompare(attr string, op FilterOp, value any) Filter {
+	return Filter{Op: op, Attribute: attr, Value: value}
+}
+
+// filterContains matches rows where the array attribute contains value.
+func filterContains(attr string, value any) Filter {
+	return Filter{Op: OpContains, Attribute: attr, Value: value}
+}
+
+// filterContainsAny matches rows where the array attribute contains any of values.
+func filterContainsAny[T any](attr string, values []T) Filter {
+	return Filter{Op: OpContainsAny, Attribute: attr, Value: values}
+}
+
+// filterContainsAllTokens matches rows where the full-text attribute contains every token of text.
+func filterContainsAllTokens(attr string, text string) Filter {
+	return Filter{Op: OpContainsAllTokens, Attribute: attr, Value: text}
+}
+
+// NamespaceMetadata is information about a namespace.
+type NamespaceMetadata struct {
+	CreatedAt          time.Time
+	ApproxRowCount     int64
+	ApproxLogicalBytes int64
+}
+
+// BackendKind is an enumeration of the supported Backend implementations.
+type BackendKind string
+
+// List of supported backends.
+var (
+	TurbopufferBackend BackendKind = "turbopuffer"
+	MemoryBackend      BackendKind = "memory"
+)
+
+func (k BackendKind) Valid() bool {
+	switch k {
+	case TurbopufferBackend, MemoryBackend:
+		return true
+	default:
+		return false
+	}
+}
+
+func newBackend() (Backend, error) {
+	kind, err := backendKind()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case MemoryBackend:
+		return newMemoryBackend(memoryDir()), nil
+	default:
+		tpuf, err := newTurbopufferClient()
+		if err != nil {
+			return nil, fmt.Errorf("creating turbopuffer client: %w", err)
+		}
+		return newTurbopufferBackend(tpuf), nil
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// checkpointInterval is how often a build checkpoints its progress.
+var checkpointInterval = 5 * time.Second
+
+// BuildCheckpoint records how far a build got, so that if it's interrupted, it can be resumed into
+// the same namespace instead of starting over. It's kept next to the staging file of the index
+// (see checkpointFilepath), which records the rest of what's needed to resume: the namespace, set,
+// mode and embedder.
+//
+// Sets are streamed, so the checksum of a set is only known once it's been read in full. Instead,
+// the checksum of every card written is recorded, and a resumed build skips the cards which are
+// unchanged, like Index.Refresh. So it's safe to resume a build after the set has changed.
+type BuildCheckpoint struct {
+	// Namespace is the namespace being built, which must match the staging file's.
+	Namespace string `json:"namespace"`
+
+	// Batch is the last batch the build wrote, along with every batch before it. Batches are
+	// numbered from 1 by each attempt at the build.
+	Batch int64 `json:"batch"`
+
+	// Cards records what the namespace may hold, like Index.Cards. Cards which may not have been
+	// written in full (e.g. because their batch was in flight) have no checksum, so they're
+	// always written again, and list every row they may have in the namespace.
+	Cards map[string]IndexedCard `json:"cards"`
+
+	// UpdatedAt is when the checkpoint was written.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Written returns how many cards the checkpoint records as written in full.
+func (c *BuildCheckpoint) Written() int {
+	var n int
+	for _, card := range c.Cards {
+		if card.Checksum != "" {
+			n += 1
+		}
+	}
+	return n
+}
+
+// checkpointFilepath is where the checkpoint of an index being built is kept.
+func checkpointFilepath(name string) string {
+	return filepath.Join(indexDir(), name+".json.checkpoint")
+}
+
+// LoadCheckpoint loads the checkpoint of an index being built. If there's none, returns nil.
+func LoadCheckpoint(name string) (*BuildCheckpoint, error) {
+	fp := checkpointFilepath(name)
+	data, err := os.ReadFile(fp)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading checkpoint %q: %w", fp, err)
+	}
+	var checkpoint BuildCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint %q: %w", fp, err)
+	}
+	return &checkpoint, nil
+}
+
+// saveCheckpoint atomically replaces the checkpoint of the index, via a temporary file.
+func (idx *Index) saveCheckpoint(batch int64, cards map[string]IndexedCard) error {
+	data, err := json.Marshal(&BuildCheckpoint{
+		Namespace: idx.Namespace,
+		Batch:     batch,
+		Cards:     cards,
+		UpdatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("encoding checkpoint of index %q: %w", idx.Name, err)
+	}
+	fp := checkpointFilepath(idx.Name)
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing checkpoint %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, fp); err != nil {
+		return fmt.Errorf("renaming checkpoint %q: %w", tmp, err)
+	}
+	return nil
+}
+
+// deleteCheckpoint deletes the checkpoint of the index, if any.
+func (idx *Index) deleteCheckpoint() error {
+	fp := checkpointFilepath(idx.Name)
+	if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("deleting checkpoint %q: %w", fp, err)
+	}
+	return nil
+}
+
+// checkpointCards returns what a namespace may hold partway through upsertEntries: what it held
+// before (previous), updated with the cards streamed so far. Cards in pending whose last batch is
+// after confirmed may not have been written in full, so they're recorded without a checksum, with
+// both their old and new rows. Cards which are no longer pending are removed from it.
+func checkpointCards(
+	previous, cards map[string]IndexedCard,
+	pending map[string]int64,
+	confirmed int64,
+) map[string]IndexedCard {
+	out := make(map[string]IndexedCard, max(len(previous), len(cards)))
+	for name, card := range previous {
+		out[name] = card
+	}
+	for name, card := range cards {
+		if batch, ok := pending[name]; ok {
+			if batch > confirmed {
+				ids := slices.Clone(previous[name].IDs)
+				for _, id := range card.IDs {
+					if !slices.Contains(ids, id) {
+						ids = append(ids, id)
+					}
+				}
+				out[name] = IndexedCard{IDs: ids}
+				continue
+			}
+			delete(pending, name)
+		}
+		out[name] = card
+	}
+	return out
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUpsertCheckpoints(t *testing.T) {
+	setFlag(t, flagUploadBatchSize, 1)
+	setFlag(t, flagUploadConcurrency, 1)
+	setFlag(t, &checkpointInterval, 0)
+
+	// Cards of about 300KB each, so 3 of them fit in a batch.
+	text := strings.Repeat("x", 300<<10)
+	set := &AtomicSet{Data: make(map[string][]AtomicCard)}
+	for i := range 12 {
+		name := fmt.Sprintf("Card %02d", i)
+		set.Data[name] = []AtomicCard{{Name: name, Text: &text}}
+	}
+
+	// The third batch fails, stopping the build.
+	memory := newMemoryBackend("")
+	failing := &flakyBackend{
+		Backend: memory,
+		err:     errors.New("connection reset"),
+		fail:    func(attempt int) bool { return attempt >= 2 },
+	}
+	var last *BuildCheckpoint
+	hooks := &uploadHooks{checkpoint: func(batch int64, cards map[string]IndexedCard) error {
+		last = &BuildCheckpoint{Batch: batch, Cards: cards}
+		return nil
+	}}
+	if _, err := upsertSet(t.Context(), failing, "mtg_cards", atomicEntries(set), nil, nil, hooks); err == nil {
+		t.Fatal("upload to a failing backend succeeded")
+	}
+	if last == nil {
+		t.Fatal("failed upload wasn't checkpointed")
+	}
+	if last.Batch != 2 || last.Written() != 6 {
+		t.Errorf("checkpointed %d cards in %d batches, want 6 in 2", last.Written(), last.Batch)
+	}
+	for name, card := range last.Cards {
+		if card.Checksum != "" && !slices.Contains(failing.upserted, card.IDs[0]) {
+			t.Errorf("checkpoint records %q as written, but it wasn't", name)
+		}
+	}
+
+	// Resuming only writes the cards which weren't checkpointed as written.
+	resumed := &flakyBackend{Backend: memory, fail: func(int) bool { return false }}
+	cards, err := upsertSet(t.Context(), resumed, "mtg_cards", atomicEntries(set), last.Cards, nil, nil)
+	if err != nil {
+		t.Fatalf("resuming upload: %v", err)
+	}
+	if len(resumed.upserted) != 6 {
+		t.Errorf("resumed upload wrote %d rows, want the 6 which weren't checkpointed", len(resumed.upserted))
+	}
+	if len(cards) != 12 {
+		t.Errorf("resumed upload holds %d cards, want 12", len(cards))
+	}
+	if meta, err := memory.Metadata(t.Context(), "mtg_cards"); err != nil || meta.ApproxRowCount != 12 {
+		t.Errorf("namespace holds %v rows (err %v), want 12", meta, err)
+	}
+}
+
+func TestCheckpointCards(t *testing.T) {
+	previous := map[string]IndexedCard{
+		"Shock":  {Checksum: "old", IDs: []string{"1"}},
+		"Opt":    {Checksum: "old", IDs: []string{"2", "3"}},
+		"Murder": {Checksum: "old", IDs: []string{"4"}},
+	}
+	cards := map[string]IndexedCard{
+		"Shock": {Checksum: "old", IDs: []string{"1"}},
+		"Opt":   {Checksum: "new", IDs: []string{"2"}},
+		"Bolt":  {Checksum: "new", IDs: []string{"5"}},
+	}
+	pending := map[string]int64{"Opt": 3, "Bolt": 2}
+
+	got := checkpointCards(previous, cards, pending, 2)
+	want := map[string]IndexedCard{
+		"Shock":  {Checksum: "old", IDs: []string{"1"}},
+		"Opt":    {IDs: []string{"2", "3"}},
+		"Murder": {Checksum: "old", IDs: []string{"4"}},
+		"Bolt":   {Checksum: "new", IDs: []string{"5"}},
+	}
+	if len(got) != len(want) {
+		t.Errorf("checkpointed %v, want %v", got, want)
+	}
+	for name, card := range want {
+		if got[name].Checksum != card.Checksum || !slices.Equal(got[name].IDs, card.IDs) {
+			t.Errorf("checkpointed %q as %+v, want %+v", name, got[name], card)
+		}
+	}
+	if _, ok := pending["Bolt"]; ok || pending["Opt"] != 3 {
+		t.Errorf("pending after checkpoint = %v, want only Opt", pending)
+	}
+}
+
+func TestResumeBuild(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			serveFixtures(t)
+			backend, _ := newTestBackend(t, kind)
+			setFlag(t, flagSet, string(Standard))
+			t.Chdir(t.TempDir())
+
+			ctx := t.Context()
+			if err := buildIndex(ctx, backend, "cards"); err != nil {
+				t.Fatalf("building index: %v", err)
+			}
+			built, err := LoadIndex("cards")
+			if err != nil || built == nil {
+				t.Fatalf("loading built index: %v (index: %v)", err, built)
+			}
+			if checkpoint, _ := LoadCheckpoint("cards"); checkpoint != nil {
+				t.Errorf("built index left its checkpoint behind")
+			}
+
+			// Turn the index back into a build which died after checkpointing every card but one.
+			staged := *built
+			staged.State = IndexBuilding
+			staged.Checksum = ""
+			staged.Cards = nil
+			if err := staged.writeFile(stagingFilepath("cards")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(indexFilepath("cards")); err != nil {
+				t.Fatal(err)
+			}
+			cards := make(map[string]IndexedCard)
+			for name, card := range built.Cards {
+				cards[name] = card
+			}
+			murder := cards["Murder"]
+			murder.Checksum = ""
+			cards["Murder"] = murder
+			if err := staged.saveCheckpoint(1, cards); err != nil {
+				t.Fatal(err)
+			}
+
+			// Resuming needs the same flags as the build.
+			setFlag(t, flagEmbedder, string(NoEmbedder))
+			if err := buildIndex(ctx, backend, "cards"); err == nil {
+				t.Error("resumed a build with a different embedder")
+			}
+			setFlag(t, flagEmbedder, string(built.Embedder))
+
+			resumed := &flakyBackend{Backend: backend, fail: func(int) bool { return false }}
+			if err := buildIndex(ctx, resumed, "cards"); err != nil {
+				t.Fatalf("resuming build: %v", err)
+			}
+			if !slices.Equal(resumed.upserted, murder.IDs) {
+				t.Errorf("resumed build wrote rows %v, want only those of Murder %v", resumed.upserted, murder.IDs)
+			}
+			index, err := LoadIndex("cards")
+			if err != nil || index == nil {
+				t.Fatalf("loading resumed index: %v (index: %v)", err, index)
+			}
+			if index.State != IndexReady || index.Namespace != built.Namespace || index.Checksum != built.Checksum {
+				t.Errorf("resumed index %+v, want a ready index like %+v", index, built)
+			}
+			if len(index.Cards) != len(built.Cards) {
+				t.Errorf("resumed index has %d cards, want %d", len(index.Cards), len(built.Cards))
+			}
+			for _, fp := range []string{stagingFilepath("cards"), checkpointFilepath("cards")} {
+				if _, err := os.Stat(fp); !os.IsNotExist(err) {
+					t.Errorf("%q exists after resuming (err: %v)", fp, err)
+				}
+			}
+		})
+	}
+}
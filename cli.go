@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Exit codes of puffingmtg. Like the flag package, invalid usage exits with 2.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command is a subcommand of puffingmtg, e.g. build.
+type command struct {
+	name string
+
+	// args names the positional arguments, e.g. NAME. Optional ones are in brackets, e.g.
+	// [COMMAND], and come last.
+	args []string
+
+	// summary is a line for the list of commands, and help is the rest of the help of the command.
+	summary, help string
+
+	// flags names the flags of allFlags the command accepts, besides commonFlags (and
+	// backendFlags, if it needs a backend).
+	flags []string
+
+	// backend is whether the command needs a backend, which is created before running it.
+	backend bool
+
+	// standalone commands don't read settings, so they accept no flags and ignore the config file.
+	standalone bool
+
+	// hidden commands are left out of the usage, e.g. those used by shell completion.
+	hidden bool
+
+	run func(ctx context.Context, backend Backend, args []string, stdout io.Writer) error
+}
+
+// commonFlags are accepted by every command.
+var commonFlags = []string{"config", "config-profile", "index-dir"}
+
+// backendFlags are accepted by every command which needs a backend.
+var backendFlags = []string{"backend", "tpuf-region", "tpuf-api-key", "tpuf-base-url", "memory-dir"}
+
+// uploadFlags are accepted by every command which writes rows to a backend.
+var uploadFlags = []string{"upload-concurrency", "upload-batch-size", "upload-retries"}
+
+// commands returns every command, in the order they're listed in the usage.
+func commands() []*command {
+	return []*command{
+		{
+			name:    "build",
+			args:    []string{"NAME"},
+			summary: "build an index of a set",
+			help: "Downloads a set from mtgjson (or reads it from -source) and uploads it to a new namespace, " +
+				"creating an index file named NAME in the index directory. If a previous build of NAME was " +
+				"interrupted, resumes it from its checkpoint, given the same -set, -index-mode and -embedder.",
+			flags:   append([]string{"set", "source", "embedder", "index-mode"}, uploadFlags...),
+			backend: true,
+			run: func(ctx context.Context, backend Backend, args []string, _ io.Writer) error {
+				if err := buildIndex(ctx, backend, args[0]); err != nil {
+					return fmt.Errorf("failed to build index %q: %w", args[0], err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "refresh",
+			args:    []string{"NAME"},
+			summary: "update an index in place if its set has changed",
+			help: "Checks whether the set of index NAME has changed on mtgjson (or in -source), and if so, " +
+				"writes the cards which changed and deletes those which are gone.",
+			flags:   append([]string{"source"}, uploadFlags...),
+			backend: true,
+			run: func(ctx context.Context, backend Backend, args []string, _ io.Writer) error {
+				if err := refreshIndex(ctx, backend, args[0]); err != nil {
+					return fmt.Errorf("failed to refresh index %q: %w", args[0], err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "delete",
+			args:    []string{"NAME"},
+			summary: "delete an index, and its namespace",
+			help: "Deletes index NAME both from the backend and from the index directory. Also cleans up an " +
+				"interrupted build of it. Indexes served by an alias can't be deleted.",
+			backend: true,
+			run: func(ctx context.Context, backend Backend, args []string, _ io.Writer) error {
+				if err := deleteIndex(ctx, backend, args[0]); err != nil {
+					return fmt.Errorf("failed to delete index %q: %w", args[0], err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "serve",
+			args:    []string{"NAME"},
+			summary: "serve an index over HTTP",
+			help: "Serves index NAME over HTTP on -listen, until interrupted. NAME may be an alias, which is " +
+				"followed as it's promoted.",
+			flags:   []string{"listen"},
+			backend: true,
+			run: func(ctx context.Context, backend Backend, args []string, _ io.Writer) error {
+				if err := serveIndex(ctx, backend, args[0]); err != nil {
+					return fmt.Errorf("failed to serve index %q: %w", args[0], err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "search",
+			args:    []string{"NAME", "[QUERY]"},
+			summary: "search an index",
+			help: "Searches index NAME for QUERY, and writes the results to stdout in -format, for scripts. " +
+				"Queries are written in Scryfall-style syntax, e.g. 't:creature c:g landfall'. NAME may " +
+				"be an alias.\n\n" +
+				"Without a QUERY, reads queries from stdin, one per line, and logs the results of each.\n\n" +
+				"The values of fields which differ between the faces of a card (e.g. text) are joined with " +
+				"\" // \" in tables and CSV, like card names, and are arrays in JSON. Queries starting with " +
+				"a - (e.g. -t:land) must come after --.\n\n" +
+				"Keyword matches are ranked by -profile: default weighs names over rules text, rules also " +
+				"matches types, keywords and rulings, and popular boosts cards played more on EDHREC. " +
+				"More profiles can be defined in the config file, under ranking.",
+			flags:   []string{"format", "fields", "k", "mode", "lang", "collapse", "profile"},
+			backend: true,
+			run: func(ctx context.Context, backend Backend, args []string, stdout io.Writer) error {
+				var query string
+				if len(args) > 1 {
+					query = args[1]
+				}
+				if err := searchIndex(ctx, backend, args[0], query, stdout); err != nil {
+					return fmt.Errorf("failed to search index %q: %w", args[0], err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "list",
+			summary: "list every index, with the size of its namespace",
+			help:    "Lists every index in the index directory, along with its state, aliases and namespace.",
+			backend: true,
+			run: func(ctx context.Context, backend Backend, _ []string, stdout io.Writer) error {
+				if err := listIndexes(ctx, backend, stdout); err != nil {
+					return fmt.Errorf("failed to list indexes: %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "describe",
+			args:    []string{"NAME"},
+			summary: "show everything known about an index",
+			help:    "Shows the metadata of index NAME, including that of its namespace.",
+			backend: true,
+			run: func(ctx context.Context, backend Backend, args []string, stdout io.Writer) error {
+				if err := describeIndex(ctx, backend, args[0], stdout); err != nil {
+					return fmt.Errorf("failed to describe index %q: %w", args[0], err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "promote",
+			args:    []string{"ALIAS=INDEX"},
+			summary: "point an alias at an index",
+			help: "Points ALIAS at INDEX, e.g. standard=standard-20261016. Servers of the alias switch to " +
+				"the index without restarting.",
+			run: func(_ context.Context, _ Backend, args []string, _ io.Writer) error {
+				if err := promoteAlias(args[0]); err != nil {
+					return fmt.Errorf("failed to promote %q: %w", args[0], err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "rollback",
+			args:    []string{"ALIAS"},
+			summary: "point an alias back at its previous index",
+			help:    "Points ALIAS back at the index it pointed at before it was last promoted.",
+			run: func(_ context.Context, _ Backend, args []string, _ io.Writer) error {
+				if err := rollbackAlias(args[0]); err != nil {
+					return fmt.Errorf("failed to roll back alias %q: %w", args[0], err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "gc",
+			summary: "delete namespaces no index refers to",
+			help: "Finds the namespaces which no index file in the index directory refers to, lists them, " +
+				"and deletes them after asking for confirmation.",
+			flags:   []string{"yes"},
+			backend: true,
+			run: func(ctx context.Context, backend Backend, _ []string, _ io.Writer) error {
+				if err := gcNamespaces(ctx, backend); err != nil {
+					return fmt.Errorf("failed to garbage collect namespaces: %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "config",
+			summary: "print every setting and where it came from",
+			help: "Prints every setting, its value and where it came from, with secrets redacted. Settings " +
+				"given as flags are shown as they'd be used.\n\n" +
+				"Besides flags, settings are read from the environment ($TURBOPUFFER_API_KEY, " +
+				"$TURBOPUFFER_REGION), and from a config file: -config, $PUFFINGMTG_CONFIG, or " +
+				"config.toml (or .yaml, .yml) in $XDG_CONFIG_HOME/puffingmtg. Its keys are the names of the " +
+				"flags below, and its profiles, chosen with -config-profile, are sections under " +
+				"\"profiles\". Flags take precedence, then the environment, the profile, and the top level " +
+				"of the config file.",
+			flags: configSettings,
+			run: func(_ context.Context, _ Backend, _ []string, stdout io.Writer) error {
+				if err := printConfig(stdout); err != nil {
+					return fmt.Errorf("failed to print config: %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			name:       "completion",
+			args:       []string{"SHELL"},
+			summary:    "print a shell completion script",
+			standalone: true,
+			help: "Prints a completion script for SHELL (bash, zsh or fish). To load it:\n\n" +
+				"  bash: source <(puffingmtg completion bash)\n" +
+				"  zsh:  puffingmtg completion zsh > \"${fpath[1]}/_puffingmtg\"\n" +
+				"  fish: puffingmtg completion fish > ~/.config/fish/completions/puffingmtg.fish",
+			run: func(_ context.Context, _ Backend, args []string, stdout io.Writer) error {
+				return writeCompletion(stdout, args[0])
+			},
+		},
+		{
+			name:       "help",
+			args:       []string{"[COMMAND]"},
+			summary:    "show the help of a command",
+			standalone: true,
+			help:       "Shows the help of COMMAND, or lists every command.",
+			run: func(_ context.Context, _ Backend, args []string, stdout io.Writer) error {
+				if len(args) == 0 {
+					usage(stdout)
+					return nil
+				}
+				cmd := findCommand(args[0])
+				if cmd == nil {
+					return fmt.Errorf("unknown command %q", args[0])
+				}
+				cmd.usage(stdout, cmd.flagSet(io.Discard))
+				return nil
+			},
+		},
+		{
+			name:   "__names",
+			hidden: true,
+			help:   "Lists the names of every index and alias, for shell completion.",
+			run: func(_ context.Context, _ Backend, _ []string, stdout io.Writer) error {
+				return listNames(stdout)
+			},
+		},
+	}
+}
+
+// findCommand returns the command with the given name, or nil if there's none.
+func findCommand(name string) *command {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// run runs the command given by args (without the program name), returning the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	if slices.Contains([]string{"-h", "-help", "--help"}, args[0]) {
+		usage(stdout)
+		return exitOK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "puffingmtg: unknown command %q\n\n", args[0])
+		usage(stderr)
+		return exitUsage
+	}
+
+	fs := cmd.flagSet(stderr)
+	args, err := parseArgs(fs, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	} else if err != nil {
+		// The flag set has already reported the error, along with the usage.
+		return exitUsage
+	}
+	if required := cmd.requiredArgs(); len(args) < required || len(args) > len(cmd.args) {
+		fmt.Fprintf(stderr, "puffingmtg %s: expected %s, got %d arguments\n\n", cmd.name, cmd.argsUsage(), len(args))
+		fs.Usage()
+		return exitUsage
+	}
+
+	if !cmd.standalone {
+		cmdline := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+		if err := loadConfig(cmdline); err != nil {
+			log.Printf("failed to load config: %v", err)
+			return exitFailure
+		}
+	}
+
+	var backend Backend
+	if cmd.backend {
+		if backend, err = newBackend(); err != nil {
+			log.Printf("failed to create backend: %v", err)
+			return exitFailure
+		}
+	}
+	if err := cmd.run(ctx, backend, args, stdout); err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// parseArgs parses flags with fs, returning the positional arguments. Unlike fs.Parse, flags may
+// come after positional arguments, e.g. build NAME -set standard, until a "--".
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// flagNames returns the names of the flags the command accepts.
+func (c *command) flagNames() []string {
+	if c.standalone {
+		return nil
+	}
+	names := slices.Clone(commonFlags)
+	if c.backend {
+		names = append(names, backendFlags...)
+	}
+	for _, name := range c.flags {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// flagSet returns a flag set which parses the flags the command accepts into allFlags, writing
+// errors and usage to w.
+func (c *command) flagSet(w io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("puffingmtg "+c.name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	for _, name := range c.flagNames() {
+		f := allFlags.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+		// The value may have been changed since, e.g. by a previous command.
+		fs.Lookup(name).DefValue = f.DefValue
+	}
+	fs.Usage = func() { c.usage(w, fs) }
+	return fs
+}
+
+// requiredArgs returns how many positional arguments the command needs.
+func (c *command) requiredArgs() int {
+	var n int
+	for _, arg := range c.args {
+		if !strings.HasPrefix(arg, "[") {
+			n += 1
+		}
+	}
+	return n
+}
+
+func (c *command) argsUsage() string {
+	if len(c.args) == 0 {
+		return "no arguments"
+	}
+	return strings.Join(c.args, " ")
+}
+
+// usage writes the help of the command, including its flags, to w.
+func (c *command) usage(w io.Writer, fs *flag.FlagSet) {
+	if c.standalone {
+		fmt.Fprintf(w, "usage: %s\n\n", strings.Join(append([]string{"puffingmtg", c.name}, c.args...), " "))
+		fmt.Fprintln(w, wrapText(c.help, helpWidth))
+		return
+	}
+	fmt.Fprintf(w, "usage: %s\n\n", strings.Join(append([]string{"puffingmtg", c.name, "[flags]"}, c.args...), " "))
+	fmt.Fprintf(w, "%s\n\n", wrapText(c.help, helpWidth))
+	fmt.Fprintln(w, "flags:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// usage writes the list of commands to w.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: puffingmtg COMMAND [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Indexes cards from mtgjson.com with turbopuffer, and searches them.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands() {
+		if !cmd.hidden {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", cmd.name, strings.Join(cmd.args, " "), cmd.summary)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `run "puffingmtg help COMMAND" for the flags of a command. settings can also come from a config file`)
+	fmt.Fprintln(w, `or the environment, see "puffingmtg help config".`)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "exits with %d on success, %d if the command failed, and %d on invalid usage.\n", exitOK, exitFailure, exitUsage)
+}
+
+// helpWidth is the width help text is wrapped to.
+const helpWidth = 100
+
+// wrapText wraps the lines of s to width, breaking between words. Indented lines are left as is.
+func wrapText(s string, width int) string {
+	var b strings.Builder
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if strings.HasPrefix(line, " ") {
+			b.WriteString(line)
+			continue
+		}
+		var n int
+		for j, word := range strings.Fields(line) {
+			if j > 0 && n+1+len(word) > width {
+				b.WriteString("\n")
+				n = 0
+			} else if j > 0 {
+				b.WriteString(" ")
+				n += 1
+			}
+			b.WriteString(word)
+			n += len(word)
+		}
+	}
+	return b.String()
+}
+
+// listNames writes the name of every index and alias in the index directory to w, one per line.
+func listNames(w io.Writer) error {
+	indexes, err := ListIndexes()
+	if err != nil {
+		return err
+	}
+	aliases, err := ListAliases()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, index := range indexes {
+		names = append(names, index.Name)
+	}
+	for _, alias := range aliases {
+		names = append(names, alias.Name)
+	}
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		fmt.Fprintln(w, name)
+	}
+	return nil
+}
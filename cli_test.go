@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// runCommand runs puffingmtg with the given arguments, returning its exit code and output. What's
+// logged goes to stderr.
+func runCommand(t *testing.T, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	var out, errOut strings.Builder
+	w := log.Writer()
+	log.SetOutput(&errOut)
+	code = run(t.Context(), args, &out, &errOut)
+	log.SetOutput(w)
+	return code, out.String(), errOut.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	keepSettings(t)
+	for _, tc := range []struct {
+		args []string
+		code int
+		want string // in stdout or stderr
+	}{
+		{nil, exitUsage, "usage: puffingmtg COMMAND"},
+		{[]string{"-h"}, exitOK, "commands:"},
+		{[]string{"bogus"}, exitUsage, `unknown command "bogus"`},
+		{[]string{"build"}, exitUsage, "expected NAME, got 0 arguments"},
+		{[]string{"build", "a", "b"}, exitUsage, "expected NAME, got 2 arguments"},
+		{[]string{"list", "cards"}, exitUsage, "expected no arguments, got 1 arguments"},
+		{[]string{"delete", "-set", "all", "cards"}, exitUsage, "flag provided but not defined: -set"},
+		{[]string{"completion", "-backend", "memory", "bash"}, exitUsage, "flag provided but not defined"},
+		{[]string{"build", "-h"}, exitOK, "-upload-concurrency"},
+		{[]string{"help", "build"}, exitOK, "usage: puffingmtg build [flags] NAME"},
+		{[]string{"help", "bogus"}, exitFailure, `unknown command "bogus"`},
+		{[]string{"completion", "tcsh"}, exitFailure, `unknown shell "tcsh"`},
+	} {
+		code, stdout, stderr := runCommand(t, tc.args...)
+		output := stdout + stderr
+		if code != tc.code || !strings.Contains(output, tc.want) {
+			t.Errorf("puffingmtg %s exited with %d, want %d with %q in output:\n%s", tc.args, code, tc.code, tc.want, output)
+		}
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	keepSettings(t)
+	serveFixtures(t)
+	indexDir, memoryDir := t.TempDir(), t.TempDir()
+	settings := []string{"-backend", "memory", "-memory-dir", memoryDir, "-index-dir", indexDir}
+
+	// Flags can come before or after the name of the index.
+	args := append([]string{"build", "-set", "standard", "cards"}, settings...)
+	if code, _, stderr := runCommand(t, args...); code != exitOK {
+		t.Fatalf("building index exited with %d:\n%s", code, stderr)
+	}
+	if *flagSet != "standard" || *flagIndexDir != indexDir {
+		t.Errorf("got -set %q and -index-dir %q, want those parsed after the name", *flagSet, *flagIndexDir)
+	}
+
+	code, stdout, _ := runCommand(t, append([]string{"list"}, settings...)...)
+	if lines := strings.Split(strings.TrimSpace(stdout), "\n"); code != exitOK || len(lines) != 2 {
+		t.Errorf("listing indexes exited with %d, want a header and 1 index:\n%s", code, stdout)
+	}
+	code, stdout, _ = runCommand(t, append([]string{"describe", "cards"}, settings...)...)
+	if code != exitOK || !strings.Contains(stdout, "namespace rows:") {
+		t.Errorf("describing index exited with %d:\n%s", code, stdout)
+	}
+	if code, stdout, _ = runCommand(t, "__names", "-index-dir", indexDir); code != exitOK || stdout != "cards\n" {
+		t.Errorf("listing names exited with %d and wrote %q, want cards", code, stdout)
+	}
+	if code, _, _ = runCommand(t, "promote", "-index-dir", indexDir, "latest=cards"); code != exitOK {
+		t.Errorf("promoting alias exited with %d", code)
+	}
+	if code, stdout, _ = runCommand(t, "__names", "-index-dir", indexDir); stdout != "cards\nlatest\n" {
+		t.Errorf("listing names exited with %d and wrote %q, want cards and latest", code, stdout)
+	}
+
+	// Searches write their results to stdout.
+	args = append([]string{"search", "cards", "landfall", "-mode", "bm25", "-format", "csv", "-fields", "name,edhrec_rank"}, settings...)
+	if code, stdout, _ = runCommand(t, args...); code != exitOK || stdout != "name,edhrec_rank\nLotus Cobra,1520\n" {
+		t.Errorf("searching exited with %d and wrote %q, want Lotus Cobra as csv", code, stdout)
+	}
+	args = append([]string{"search", "cards", "-format", "jsonl", "-fields", "name", "-k", "20"}, settings...)
+	if code, stdout, _ = runCommand(t, append(args, "--", "-t:creature")...); code != exitOK || strings.Count(stdout, "\n") != 5 {
+		t.Errorf("searching for non-creatures exited with %d and wrote %q, want 5 results", code, stdout)
+	}
+	if code, _, _ = runCommand(t, append([]string{"search", "cards", "x", "-fields", "bogus"}, settings...)...); code != exitFailure {
+		t.Errorf("searching for an unknown field exited with %d, want %d", code, exitFailure)
+	}
+	args = append([]string{"search", "cards", "leave", "-profile", "rules", "-mode", "bm25", "-format", "csv", "-fields", "name"}, settings...)
+	if code, stdout, _ = runCommand(t, args...); code != exitOK || stdout != "name\nOpt\n" {
+		t.Errorf("searching rulings exited with %d and wrote %q, want Opt", code, stdout)
+	}
+	if code, _, _ = runCommand(t, append([]string{"search", "cards", "x", "-profile", "bogus"}, settings...)...); code != exitFailure {
+		t.Errorf("searching with an unknown ranking profile exited with %d, want %d", code, exitFailure)
+	}
+
+	// Failures exit with 1.
+	if code, _, stderr := runCommand(t, append([]string{"build", "cards"}, settings...)...); code != exitFailure || !strings.Contains(stderr, "already exists") {
+		t.Errorf("rebuilding an existing index exited with %d, want %d:\n%s", code, exitFailure, stderr)
+	}
+	if code, _, _ = runCommand(t, append([]string{"describe", "missing"}, settings...)...); code != exitFailure {
+		t.Errorf("describing a missing index exited with %d, want %d", code, exitFailure)
+	}
+	if code, _, _ = runCommand(t, append([]string{"delete", "cards"}, settings...)...); code != exitFailure {
+		t.Errorf("deleting an aliased index exited with %d, want %d", code, exitFailure)
+	}
+	if code, _, _ = runCommand(t, "list", "-backend", "postgres"); code != exitFailure {
+		t.Errorf("listing indexes with an invalid backend exited with %d, want %d", code, exitFailure)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args, want []string
+		set        string
+	}{
+		{[]string{"-set", "all", "cards"}, []string{"cards"}, "all"},
+		{[]string{"cards", "-set", "all"}, []string{"cards"}, "all"},
+		{[]string{"cards", "--", "-set", "all"}, []string{"cards", "-set", "all"}, ""},
+		{[]string{"a", "-set=all", "b"}, []string{"a", "b"}, "all"},
+	} {
+		var set string
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.StringVar(&set, "set", "", "")
+		got, err := parseArgs(fs, tc.args)
+		if err != nil || !slices.Equal(got, tc.want) || set != tc.set {
+			t.Errorf("parseArgs(%q) = %q with -set %q (err %v), want %q with -set %q", tc.args, got, set, err, tc.want, tc.set)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range completionShells {
+		code, stdout, stderr := runCommand(t, "completion", shell)
+		if code != exitOK {
+			t.Fatalf("completion %s exited with %d:\n%s", shell, code, stderr)
+		}
+		for _, cmd := range completedCommands() {
+			if !strings.Contains(stdout, cmd.name) {
+				t.Errorf("%s completion is missing command %q", shell, cmd.name)
+			}
+		}
+		if strings.Contains(stdout, "__names\n") || !strings.Contains(stdout, "puffingmtg __names") {
+			t.Errorf("%s completion should complete names with __names, without offering it", shell)
+		}
+
+		// Check the script's syntax, if the shell is installed.
+		path, err := exec.LookPath(shell)
+		if err != nil {
+			continue
+		}
+		script := filepath.Join(t.TempDir(), "puffingmtg."+shell)
+		if err := os.WriteFile(script, []byte(stdout), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(path, "-n", script).CombinedOutput(); err != nil {
+			t.Errorf("%s completion isn't valid: %v\n%s", shell, err, out)
+		}
+	}
+}
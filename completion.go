@@ -0,0 +1,292 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// completionShells are the shells puffingmtg completion writes scripts for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// flagValues are the values shell completion offers for flags which take one of a few.
+var flagValues = map[string][]string{
+	"backend":    {string(TurbopufferBackend), string(MemoryBackend)},
+	"embedder":   {string(HashEmbedder), string(NoEmbedder)},
+	"index-mode": {string(CardMode), string(PrintingMode)},
+	"format":     {string(TableFormat), string(JSONFormat), string(JSONLFormat), string(CSVFormat)},
+	"mode":       {string(KeywordSearch), string(VectorSearch), string(HybridSearch)},
+	"profile":    slices.Sorted(maps.Keys(rankingProfiles)),
+	"set": {
+		string(All),
+		string(Vintage),
+		string(Legacy),
+		string(Modern),
+		string(Pioneer),
+		string(Standard),
+		string(Pauper),
+		string(AllPrintings),
+	},
+}
+
+// fileFlags and dirFlags are the flags for which shell completion offers paths.
+var (
+	fileFlags = []string{"config", "source"}
+	dirFlags  = []string{"index-dir", "memory-dir"}
+)
+
+// writeCompletion writes a completion script for the given shell to w. The scripts complete
+// commands, their flags, the values of flags which take one of a few, and the names of indexes
+// and aliases, which they get from the hidden __names command.
+func writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		return writeBashCompletion(w)
+	case "zsh":
+		return writeZshCompletion(w)
+	case "fish":
+		return writeFishCompletion(w)
+	}
+	return fmt.Errorf("unknown shell %q, must be one of %s", shell, strings.Join(completionShells, ", "))
+}
+
+// completedCommands returns the commands which shell completion offers.
+func completedCommands() []*command {
+	return slices.DeleteFunc(commands(), func(cmd *command) bool { return cmd.hidden })
+}
+
+// argValues returns what shell completion offers for a positional argument, given its name (see
+// command.args): fixed values, or names of indexes and aliases.
+func argValues(arg string) (values []string, names bool) {
+	switch strings.Trim(arg, "[]") {
+	case "NAME", "ALIAS", "ALIAS=INDEX":
+		return nil, true
+	case "SHELL":
+		return completionShells, false
+	case "COMMAND":
+		for _, cmd := range completedCommands() {
+			values = append(values, cmd.name)
+		}
+		return values, false
+	}
+	return nil, false
+}
+
+// firstArgValues returns what shell completion offers for the first positional argument of the
+// command, see argValues. Later arguments are only completed by zsh.
+func (c *command) firstArgValues() (values []string, names bool) {
+	if len(c.args) == 0 {
+		return nil, false
+	}
+	return argValues(c.args[0])
+}
+
+// takesValue returns whether the flag needs a value, i.e. isn't a boolean.
+func takesValue(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// flagSummary shortens the usage of a flag to its first clause, for shell completion menus.
+func flagSummary(usage string) string {
+	for _, sep := range []string{", e.g.", " (", ". ", ": "} {
+		usage, _, _ = strings.Cut(usage, sep)
+	}
+	return usage
+}
+
+func writeBashCompletion(w io.Writer) error {
+	var commandNames []string
+	for _, cmd := range completedCommands() {
+		commandNames = append(commandNames, cmd.name)
+	}
+
+	var b strings.Builder
+	b.WriteString("# bash completion for puffingmtg, generated by: puffingmtg completion bash\n\n")
+	b.WriteString("_puffingmtg() {\n")
+	b.WriteString("\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+	b.WriteString("\tCOMPREPLY=()\n")
+	b.WriteString("\tif ((COMP_CWORD == 1)); then\n")
+	fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames, " "))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\tfi\n\n")
+
+	// The values of flags.
+	var valued []string
+	allFlags.VisitAll(func(f *flag.Flag) {
+		if takesValue(f) {
+			valued = append(valued, "-"+f.Name)
+		}
+	})
+	b.WriteString("\tcase $prev in\n")
+	for _, name := range slices.Sorted(maps.Keys(flagValues)) {
+		fmt.Fprintf(&b, "\t-%s)\n", name)
+		fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(flagValues[name], " "))
+		b.WriteString("\t\treturn\n")
+		b.WriteString("\t\t;;\n")
+	}
+	fmt.Fprintf(&b, "\t-%s)\n", strings.Join(fileFlags, "|-"))
+	b.WriteString("\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t\t;;\n")
+	fmt.Fprintf(&b, "\t-%s)\n", strings.Join(dirFlags, "|-"))
+	b.WriteString("\t\tCOMPREPLY=($(compgen -d -- \"$cur\"))\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t\t;;\n")
+	fmt.Fprintf(&b, "\t%s)\n", strings.Join(valued, "|"))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\t\t;;\n")
+	b.WriteString("\tesac\n\n")
+
+	// The flags and positional arguments of each command.
+	b.WriteString("\tlocal flags values names\n")
+	b.WriteString("\tcase ${COMP_WORDS[1]} in\n")
+	for _, cmd := range completedCommands() {
+		values, names := cmd.firstArgValues()
+		fmt.Fprintf(&b, "\t%s)\n", cmd.name)
+		var flags []string
+		for _, name := range cmd.flagNames() {
+			flags = append(flags, "-"+name)
+		}
+		fmt.Fprintf(&b, "\t\tflags=%q values=%q names=%t\n", strings.Join(flags, " "), strings.Join(values, " "), names)
+		b.WriteString("\t\t;;\n")
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("\tif [[ $cur == -* ]]; then\n")
+	b.WriteString("\t\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	b.WriteString("\telif [[ $names == true ]]; then\n")
+	b.WriteString("\t\tCOMPREPLY=($(compgen -W \"$(puffingmtg __names 2>/dev/null)\" -- \"$cur\"))\n")
+	b.WriteString("\telse\n")
+	b.WriteString("\t\tCOMPREPLY=($(compgen -W \"$values\" -- \"$cur\"))\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("}\n\n")
+	b.WriteString("complete -F _puffingmtg puffingmtg\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeZshCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("#compdef puffingmtg\n")
+	b.WriteString("# zsh completion for puffingmtg, generated by: puffingmtg completion zsh\n\n")
+	b.WriteString("_puffingmtg_names() {\n")
+	b.WriteString("\tcompadd -- ${(f)\"$(puffingmtg __names 2>/dev/null)\"}\n")
+	b.WriteString("}\n\n")
+	b.WriteString("_puffingmtg() {\n")
+	b.WriteString("\tlocal -a commands\n")
+	b.WriteString("\tcommands=(\n")
+	for _, cmd := range completedCommands() {
+		fmt.Fprintf(&b, "\t\t%s\n", zshQuote(cmd.name+":"+cmd.summary))
+	}
+	b.WriteString("\t)\n")
+	b.WriteString("\tif ((CURRENT == 2)); then\n")
+	b.WriteString("\t\t_describe command commands\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\tfi\n\n")
+	b.WriteString("\tshift words\n")
+	b.WriteString("\t((CURRENT--))\n")
+	b.WriteString("\tcase $words[1] in\n")
+	for _, cmd := range completedCommands() {
+		fmt.Fprintf(&b, "\t%s)\n", cmd.name)
+		b.WriteString("\t\t_arguments")
+		for _, name := range cmd.flagNames() {
+			f := allFlags.Lookup(name)
+			spec := fmt.Sprintf("-%s[%s]", name, zshEscape(flagSummary(f.Usage)))
+			switch {
+			case flagValues[name] != nil:
+				spec += fmt.Sprintf(":%s:(%s)", name, strings.Join(flagValues[name], " "))
+			case slices.Contains(fileFlags, name):
+				spec += ":file:_files"
+			case slices.Contains(dirFlags, name):
+				spec += ":directory:_files -/"
+			case takesValue(f):
+				spec += ":" + name + ": "
+			}
+			fmt.Fprintf(&b, " \\\n\t\t\t%s", zshQuote(spec))
+		}
+		for _, arg := range cmd.args {
+			spec := ":"
+			if strings.HasPrefix(arg, "[") {
+				spec = "::"
+			}
+			spec += strings.ToLower(strings.Trim(arg, "[]")) + ":"
+			switch values, names := argValues(arg); {
+			case names:
+				spec += "_puffingmtg_names"
+			case len(values) > 0:
+				spec += "(" + strings.Join(values, " ") + ")"
+			default:
+				spec += " "
+			}
+			fmt.Fprintf(&b, " \\\n\t\t\t%s", zshQuote(spec))
+		}
+		b.WriteString("\n\t\t;;\n")
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n\n")
+	// Whether the script was autoloaded from $fpath, or sourced.
+	b.WriteString("if [[ $zsh_eval_context[-1] == loadautofunc ]]; then\n")
+	b.WriteString("\t_puffingmtg \"$@\"\n")
+	b.WriteString("else\n")
+	b.WriteString("\tcompdef _puffingmtg puffingmtg\n")
+	b.WriteString("fi\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// zshQuote quotes s as a single word for zsh.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshEscape escapes s for the description of an option to _arguments.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func writeFishCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# fish completion for puffingmtg, generated by: puffingmtg completion fish\n\n")
+	b.WriteString("complete -c puffingmtg -f\n")
+	for _, cmd := range completedCommands() {
+		fmt.Fprintf(
+			&b,
+			"complete -c puffingmtg -n __fish_use_subcommand -a %s -d %s\n",
+			cmd.name,
+			fishQuote(cmd.summary),
+		)
+	}
+	for _, cmd := range completedCommands() {
+		b.WriteString("\n")
+		cond := fishQuote("__fish_seen_subcommand_from " + cmd.name)
+		for _, name := range cmd.flagNames() {
+			f := allFlags.Lookup(name)
+			fmt.Fprintf(&b, "complete -c puffingmtg -n %s -o %s", cond, name)
+			switch {
+			case flagValues[name] != nil:
+				fmt.Fprintf(&b, " -x -a %s", fishQuote(strings.Join(flagValues[name], " ")))
+			case slices.Contains(fileFlags, name) || slices.Contains(dirFlags, name):
+				b.WriteString(" -r -F")
+			case takesValue(f):
+				b.WriteString(" -x")
+			}
+			fmt.Fprintf(&b, " -d %s\n", fishQuote(flagSummary(f.Usage)))
+		}
+		values, names := cmd.firstArgValues()
+		if names {
+			fmt.Fprintf(&b, "complete -c puffingmtg -n %s -a '(puffingmtg __names 2>/dev/null)'\n", cond)
+		} else if len(values) > 0 {
+			fmt.Fprintf(&b, "complete -c puffingmtg -n %s -a %s\n", cond, fishQuote(strings.Join(values, " ")))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fishQuote quotes s as a single word for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
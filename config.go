@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings can come from several places. From highest precedence to lowest:
+//
+//  1. flags on the command line, e.g. -tpuf-region
+//  2. environment variables, see configEnv
+//  3. the section of the config file for the profile in use, see -config-profile
+//  4. the top level of the config file
+//  5. the default of the flag
+//
+// The config file is TOML (.toml) or YAML (.yaml, .yml). It's read from -config, or
+// $PUFFINGMTG_CONFIG, or else config.toml (or .yaml, .yml) in $XDG_CONFIG_HOME/puffingmtg (or
+// ~/.config/puffingmtg) if it exists. Its keys are the names of the flags in configSettings, and
+// profiles are sections under "profiles", e.g.:
+//
+//	tpuf-region = "gcp-us-central1"
+//
+//	[profiles.staging]
+//	tpuf-api-key = "tpuf_..."
+//	index-dir = "/var/lib/puffingmtg/staging"
+//
+//	[profiles.prod]
+//	tpuf-api-key = "tpuf_..."
+//	tpuf-region = "gcp-us-east4"
+//
+// Ranking profiles for search are sections under "ranking", see RankingProfile.
+
+// configSettings are the flags which can be set by the config file. The others (e.g. -yes) only
+// make sense for a single command, so they're only accepted on the command line.
+var configSettings = []string{
+	"tpuf-api-key",
+	"tpuf-region",
+	"tpuf-base-url",
+	"backend",
+	"memory-dir",
+	"index-dir",
+	"listen",
+	"embedder",
+	"index-mode",
+	"set",
+	"source",
+	"upload-concurrency",
+	"upload-batch-size",
+	"upload-retries",
+}
+
+// configEnv maps environment variables onto the settings they set.
+var configEnv = map[string]string{
+	"TURBOPUFFER_API_KEY": "tpuf-api-key",
+	"TURBOPUFFER_REGION":  "tpuf-region",
+}
+
+// secretSettings are redacted by puffingmtg config.
+var secretSettings = map[string]bool{
+	"tpuf-api-key": true,
+}
+
+// configSources records where the value of each setting came from, for puffingmtg config. Settings
+// which aren't in it have their default value.
+var configSources = make(map[string]string)
+
+// loadConfig applies the config file and environment variables to every setting which wasn't set
+// on the command line, given as the names of the flags which were.
+func loadConfig(cmdline map[string]bool) error {
+	clear(configSources)
+	clear(configRankingProfiles)
+	set := func(name, value, source string) error {
+		if cmdline[name] {
+			return nil
+		}
+		if err := allFlags.Set(name, value); err != nil {
+			return fmt.Errorf("setting %s from %s: %w", name, source, err)
+		}
+		configSources[name] = source
+		return nil
+	}
+
+	fp, err := configFilepath()
+	if err != nil {
+		return err
+	}
+	profile := cmp.Or(*flagConfigProfile, os.Getenv("PUFFINGMTG_CONFIG_PROFILE"))
+	if fp != "" {
+		config, err := readConfig(fp)
+		if err != nil {
+			return err
+		}
+		maps.Copy(configRankingProfiles, config.Ranking)
+		for key, value := range config.Settings {
+			if err := set(key, value, fp); err != nil {
+				return err
+			}
+		}
+		if profile != "" {
+			settings, ok := config.Profiles[profile]
+			if !ok {
+				return fmt.Errorf("profile %q isn't in config file %q", profile, fp)
+			}
+			for key, value := range settings {
+				if err := set(key, value, fmt.Sprintf("%s (profile %s)", fp, profile)); err != nil {
+					return err
+				}
+			}
+		}
+	} else if profile != "" {
+		return fmt.Errorf("profile %q was chosen, but there's no config file", profile)
+	}
+
+	for env, name := range configEnv {
+		if value := os.Getenv(env); value != "" {
+			if err := set(name, value, "$"+env); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name := range cmdline {
+		configSources[name] = "command line"
+	}
+	return nil
+}
+
+// configFilepath returns the path of the config file to read, or "" if there's none.
+func configFilepath() (string, error) {
+	if fp := cmp.Or(*flagConfig, os.Getenv("PUFFINGMTG_CONFIG")); fp != "" {
+		return fp, nil
+	}
+	dir := configDir()
+	for _, base := range []string{"config.toml", "config.yaml", "config.yml"} {
+		fp := filepath.Join(dir, base)
+		if _, err := os.Stat(fp); err == nil {
+			return fp, nil
+		} else if !os.IsNotExist(err) {
+			return "", fmt.Errorf("checking for config file %q: %w", fp, err)
+		}
+	}
+	return "", nil
+}
+
+// configDir returns the directory holding the default config file: puffingmtg under the XDG
+// config directory.
+func configDir() string {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(configHome) {
+		return filepath.Join(configHome, "puffingmtg")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".config", "puffingmtg")
+}
+
+// configFile is a parsed config file, with every setting as a flag value.
+type configFile struct {
+	Settings map[string]string
+	Profiles map[string]map[string]string
+	Ranking  map[string]RankingProfile
+}
+
+func readConfig(fp string) (*configFile, error) {
+	data, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file %q: %w", fp, err)
+	}
+	raw := make(map[string]any)
+	switch filepath.Ext(fp) {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		err = errors.New("unsupported file extension, must be one of .toml, .yaml, .yml")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding config file %q: %w", fp, err)
+	}
+
+	config := &configFile{Profiles: make(map[string]map[string]string)}
+	profiles, _ := raw["profiles"].(map[string]any)
+	if _, ok := raw["profiles"]; ok && profiles == nil {
+		return nil, fmt.Errorf("config file %q: profiles must be a table of profiles", fp)
+	}
+	delete(raw, "profiles")
+	if config.Ranking, err = rankingProfilesConfig(raw["ranking"]); err != nil {
+		return nil, fmt.Errorf("config file %q: %w", fp, err)
+	}
+	delete(raw, "ranking")
+	if config.Settings, err = configValues(raw); err != nil {
+		return nil, fmt.Errorf("config file %q: %w", fp, err)
+	}
+	for name, settings := range profiles {
+		settings, ok := settings.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("config file %q: profile %q must be a table of settings", fp, name)
+		}
+		if config.Profiles[name], err = configValues(settings); err != nil {
+			return nil, fmt.Errorf("config file %q: profile %q: %w", fp, name, err)
+		}
+	}
+	return config, nil
+}
+
+// rankingProfilesConfig decodes the ranking profiles of a config file, checking they're valid.
+func rankingProfilesConfig(raw any) (map[string]RankingProfile, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	if _, ok := raw.(map[string]any); !ok {
+		return nil, errors.New("ranking must be a table of ranking profiles")
+	}
+	// TOML and YAML decode to the same types as JSON, so JSON decodes them into profiles.
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("decoding ranking profiles: %w", err)
+	}
+	var profiles map[string]json.RawMessage
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("decoding ranking profiles: %w", err)
+	}
+	ranking := make(map[string]RankingProfile, len(profiles))
+	for name, data := range profiles {
+		var profile RankingProfile
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&profile); err != nil {
+			return nil, fmt.Errorf("ranking profile %q: %w", name, err)
+		}
+		if err := profile.Validate(); err != nil {
+			return nil, fmt.Errorf("ranking profile %q: %w", name, err)
+		}
+		ranking[name] = profile
+	}
+	return ranking, nil
+}
+
+// configValues converts the settings of a config file into flag values, checking they're all
+// settings.
+func configValues(raw map[string]any) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if !slices.Contains(configSettings, key) {
+			return nil, fmt.Errorf(
+				"unknown setting %q, must be one of profiles, ranking, %s",
+				key,
+				strings.Join(configSettings, ", "),
+			)
+		}
+		switch value.(type) {
+		case string, bool, int, int64, float64:
+			values[key] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("setting %q must be a string, number or boolean, got %T", key, value)
+		}
+	}
+	return values, nil
+}
+
+// printConfig writes every setting, where it came from and its value, with secrets redacted.
+func printConfig(w io.Writer) error {
+	fp, err := configFilepath()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "config file:\t%s\n", cmp.Or(fp, "-"))
+	fmt.Fprintf(tw, "profile:\t%s\n", cmp.Or(*flagConfigProfile, os.Getenv("PUFFINGMTG_CONFIG_PROFILE"), "-"))
+	fmt.Fprintf(tw, "ranking profiles:\t%s\n", strings.Join(profileNames(), ", "))
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, name := range configSettings {
+		value := allFlags.Lookup(name).Value.String()
+		if secretSettings[name] {
+			value = redact(value)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, cmp.Or(value, "-"), cmp.Or(configSources[name], "default"))
+	}
+	return tw.Flush()
+}
+
+// redact hides a secret, leaving its last few characters to tell secrets apart.
+func redact(secret string) string {
+	switch {
+	case secret == "":
+		return ""
+	case len(secret) < 12:
+		return "****"
+	default:
+		return "****" + secret[len(secret)-4:]
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keepSettings restores every setting once the test is done, and keeps the test from reading
+// settings from the user's config file or environment.
+func keepSettings(t *testing.T) {
+	t.Helper()
+	values := make(map[string]string)
+	allFlags.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	t.Cleanup(func() {
+		for name, value := range values {
+			if err := allFlags.Set(name, value); err != nil {
+				t.Errorf("restoring -%s: %v", name, err)
+			}
+		}
+		clear(configRankingProfiles)
+	})
+	setFlag(t, flagConfig, "")
+	setFlag(t, flagConfigProfile, "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("PUFFINGMTG_CONFIG", "")
+	t.Setenv("PUFFINGMTG_CONFIG_PROFILE", "")
+	t.Setenv("TURBOPUFFER_API_KEY", "")
+	t.Setenv("TURBOPUFFER_REGION", "")
+}
+
+func writeConfig(t *testing.T, name, config string) string {
+	t.Helper()
+	fp := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fp, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+const tomlConfig = `
+tpuf-region = "gcp-us-central1"
+backend = "memory"
+upload-concurrency = 8
+
+[profiles.staging]
+tpuf-api-key = "tpuf_staging_0123456789"
+index-dir = "/staging"
+
+[profiles.prod]
+tpuf-api-key = "tpuf_prod_0123456789"
+tpuf-region = "gcp-us-east4"
+`
+
+const yamlConfig = `
+tpuf-region: gcp-us-central1
+backend: memory
+upload-concurrency: 8
+profiles:
+  staging:
+    tpuf-api-key: tpuf_staging_0123456789
+    index-dir: /staging
+  prod:
+    tpuf-api-key: tpuf_prod_0123456789
+    tpuf-region: gcp-us-east4
+`
+
+func TestLoadConfig(t *testing.T) {
+	for name, config := range map[string]string{"config.toml": tomlConfig, "config.yaml": yamlConfig} {
+		t.Run(name, func(t *testing.T) {
+			keepSettings(t)
+			setFlag(t, flagConfig, writeConfig(t, name, config))
+
+			// The top level applies to every profile.
+			if err := loadConfig(nil); err != nil {
+				t.Fatalf("loading config: %v", err)
+			}
+			if *flagTpufRegion != "gcp-us-central1" || *flagBackend != "memory" || *flagUploadConcurrency != 8 {
+				t.Errorf(
+					"got region %q, backend %q and concurrency %d, want the top level of the config",
+					*flagTpufRegion,
+					*flagBackend,
+					*flagUploadConcurrency,
+				)
+			}
+			if *flagTpufApiKey != "" {
+				t.Errorf("got api key %q without a profile, want none", *flagTpufApiKey)
+			}
+
+			// Profiles override the top level, the environment overrides the config file, and
+			// the command line overrides everything.
+			setFlag(t, flagConfigProfile, "prod")
+			t.Setenv("TURBOPUFFER_API_KEY", "tpuf_env_0123456789")
+			setFlag(t, flagIndexDir, "/cmdline")
+			if err := loadConfig(map[string]bool{"index-dir": true}); err != nil {
+				t.Fatalf("loading config: %v", err)
+			}
+			for _, tc := range []struct {
+				name, got, want, source string
+			}{
+				{"tpuf-region", *flagTpufRegion, "gcp-us-east4", "(profile prod)"},
+				{"tpuf-api-key", *flagTpufApiKey, "tpuf_env_0123456789", "$TURBOPUFFER_API_KEY"},
+				{"index-dir", *flagIndexDir, "/cmdline", "command line"},
+			} {
+				if tc.got != tc.want || !strings.HasSuffix(configSources[tc.name], tc.source) {
+					t.Errorf(
+						"%s = %q from %q, want %q from %q",
+						tc.name,
+						tc.got,
+						configSources[tc.name],
+						tc.want,
+						tc.source,
+					)
+				}
+			}
+
+			var out strings.Builder
+			if err := printConfig(&out); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(out.String(), "tpuf_env_0123456789") || !strings.Contains(out.String(), "****6789") {
+				t.Errorf("printed config doesn't redact the api key:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		file, config, profile string
+	}{
+		"unknown setting":   {"config.toml", `serve-index = "cards"`, ""},
+		"unknown profile":   {"config.toml", tomlConfig, "dev"},
+		"invalid value":     {"config.yaml", "upload-concurrency: lots", ""},
+		"nested setting":    {"config.yaml", "backend: [memory]", ""},
+		"unsupported file":  {"config.json", `{"backend": "memory"}`, ""},
+		"malformed profile": {"config.toml", `profiles = "prod"`, ""},
+		"malformed ranking": {"config.toml", `ranking = "rules"`, ""},
+		"unknown weight":    {"config.toml", "[ranking.flavor]\nweights = { flavor_text = 1 }", ""},
+		"negative boost":    {"config.yaml", "ranking:\n  pop:\n    weights: {name: 1}\n    popularity_boost: -1", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			keepSettings(t)
+			setFlag(t, flagConfig, writeConfig(t, tc.file, tc.config))
+			setFlag(t, flagConfigProfile, tc.profile)
+			if err := loadConfig(nil); err == nil {
+				t.Error("loading config succeeded")
+			}
+		})
+	}
+
+	// A profile needs a config file to come from.
+	keepSettings(t)
+	t.Setenv("PUFFINGMTG_CONFIG_PROFILE", "prod")
+	if err := loadConfig(nil); err == nil {
+		t.Error("loading a profile without a config file succeeded")
+	}
+}
+
+func TestDefaultConfigFile(t *testing.T) {
+	keepSettings(t)
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "puffingmtg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "puffingmtg", "config.yml"), []byte("backend: memory\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	if *flagBackend != "memory" {
+		t.Errorf("backend = %q, want memory from the default config file", *flagBackend)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for secret, want := range map[string]string{
+		"":                    "",
+		"short":               "****",
+		"tpuf_0123456789abcd": "****abcd",
+	} {
+		if got := redact(secret); got != want {
+			t.Errorf("redact(%q) = %q, want %q", secret, got, want)
+		}
+	}
+}
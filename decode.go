@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// entryStream streams the entries of a set as they're decoded: it calls yield with the key and
+// faces of each entry in turn (e.g. a card name and the card's faces), and returns the first error
+// yield returns. Keys are unique within a stream.
+type entryStream[T any] func(yield func(key string, faces []T) error) error
+
+// mtgjson files are far too large to decode at once (AllPrintings is gigabytes once decoded), so
+// they're decoded token by token instead, one entry of their data at a time. Every file is an
+// object holding "meta" and "data":
+//
+//   - atomic files (e.g. AtomicCards.json) map card names to their faces in data.
+//   - set files (e.g. MH3.json) have a single set as data, holding every printing in the set.
+//   - AllPrintings.json maps set codes to sets in data.
+
+// streamCards decodes the set read from r, calling yield with each card as soon as it's decoded.
+// Sets with printings are normalized to the atomic model, taking each card from its first
+// printing. Returns the metadata of the set and the hex SHA256 of everything read from r.
+func streamCards(r io.Reader, set Set, yield func(name string, faces []AtomicCard) error) (Meta, string, error) {
+	switch {
+	case set == AllPrintings:
+		normalize := atomicNormalizer(yield)
+		return decodeFile(r, func(dec *json.Decoder) error {
+			return decodeObject(dec, func(code string) error {
+				var data SetData
+				if err := dec.Decode(&data); err != nil {
+					return fmt.Errorf("decoding set %q: %w", code, err)
+				}
+				return normalize(data)
+			})
+		})
+	case set.HasPrintings():
+		normalize := atomicNormalizer(yield)
+		return decodeFile(r, func(dec *json.Decoder) error {
+			var data SetData
+			if err := dec.Decode(&data); err != nil {
+				return fmt.Errorf("decoding set: %w", err)
+			}
+			return normalize(data)
+		})
+	default:
+		return decodeFile(r, func(dec *json.Decoder) error {
+			return decodeObject(dec, func(name string) error {
+				var faces []AtomicCard
+				if err := dec.Decode(&faces); err != nil {
+					return fmt.Errorf("decoding card %q: %w", name, err)
+				}
+				return yield(name, faces)
+			})
+		})
+	}
+}
+
+// streamPrintings decodes a set with printings read from r, calling yield with each set in it as
+// soon as it's decoded: the only set of a set file, or each set of AllPrintings. Returns the
+// metadata of the set and the hex SHA256 of everything read from r.
+func streamPrintings(r io.Reader, set Set, yield func(data SetData) error) (Meta, string, error) {
+	switch {
+	case set == AllPrintings:
+		return decodeFile(r, func(dec *json.Decoder) error {
+			return decodeObject(dec, func(code string) error {
+				var data SetData
+				if err := dec.Decode(&data); err != nil {
+					return fmt.Errorf("decoding set %q: %w", code, err)
+				}
+				return yield(data)
+			})
+		})
+	case set.HasPrintings():
+		code, _ := set.Code()
+		return decodeFile(r, func(dec *json.Decoder) error {
+			var data SetData
+			if err := dec.Decode(&data); err != nil {
+				return fmt.Errorf("decoding set: %w", err)
+			}
+			if data.Code == "" {
+				data.Code = code
+			}
+			return yield(data)
+		})
+	default:
+		return Meta{}, "", fmt.Errorf("set %q has no printings, use allprintings or a set code", set)
+	}
+}
+
+// atomicNormalizer returns a function which normalizes the printings of each set it's given into
+// cards, yielding the cards which weren't already yielded for an earlier set. A card printed more
+// than once in a set (e.g. alternate arts or showcase frames) is taken from its first printing.
+func atomicNormalizer(yield func(name string, faces []AtomicCard) error) func(SetData) error {
+	yielded := make(map[string]bool)
+	return func(data SetData) error {
+		var (
+			names []string
+			cards = make(map[string][]AtomicCard)
+			sides = make(map[string]map[string]bool)
+		)
+		for _, card := range data.Cards {
+			if yielded[card.Name] {
+				continue
+			}
+			if _, ok := cards[card.Name]; !ok {
+				names = append(names, card.Name)
+				sides[card.Name] = make(map[string]bool)
+			}
+			var side string
+			if card.Side != nil {
+				side = *card.Side
+			}
+			if sides[card.Name][side] {
+				continue
+			}
+			sides[card.Name][side] = true
+			cards[card.Name] = append(cards[card.Name], card.AtomicCard)
+		}
+		for _, name := range names {
+			yielded[name] = true
+			if err := yield(name, cards[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// decodeFile decodes an mtgjson file read from r, calling data to decode the value of its "data"
+// key. Returns the metadata of the file and the hex SHA256 of everything read from r.
+func decodeFile(r io.Reader, data func(dec *json.Decoder) error) (Meta, string, error) {
+	// As we're reading the set for JSON deserialization, we'll compute a rolling checksum of the
+	// underlying data. We'll store this in the index object.
+	var (
+		hasher = sha256.New()
+		tee    = io.TeeReader(r, hasher)
+		dec    = json.NewDecoder(tee)
+		meta   Meta
+	)
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "meta":
+			if err := dec.Decode(&meta); err != nil {
+				return fmt.Errorf("decoding meta: %w", err)
+			}
+			return nil
+		case "data":
+			return data(dec)
+		default:
+			var skip json.RawMessage
+			return dec.Decode(&skip)
+		}
+	})
+	if err != nil {
+		return Meta{}, "", err
+	}
+	// The decoder stops at the end of the object, so hash anything after it too (e.g. a trailing
+	// newline), to get the checksum of the whole file.
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return Meta{}, "", fmt.Errorf("reading set: %w", err)
+	}
+	return meta, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// decodeObject walks the JSON object at the decoder's position, calling fn with each key. fn must
+// decode the key's value before returning.
+func decodeObject(dec *json.Decoder, fn func(key string) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("decoding set: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("decoding set: expected an object, got %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("decoding set: %w", err)
+		}
+		if err := fn(tok.(string)); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("decoding set: %w", err)
+	}
+	return nil
+}
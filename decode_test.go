@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStreamAllPrintings(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "AllPrintings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cards are taken from their first printing, so Lightning Bolt comes from TST, not TS2.
+	var names []string
+	cards := make(map[string][]AtomicCard)
+	meta, _, err := streamCards(bytes.NewReader(data), AllPrintings, func(name string, faces []AtomicCard) error {
+		names = append(names, name)
+		cards[name] = faces
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Version != "5.2.2+20261001" {
+		t.Errorf("version = %q, want 5.2.2+20261001", meta.Version)
+	}
+	slices.Sort(names)
+	if want := []string{"Counterspell", "Lightning Bolt", "Shock", "Wear // Tear"}; !slices.Equal(names, want) {
+		t.Errorf("streamed cards %v, want %v", names, want)
+	}
+	bolt := cards["Lightning Bolt"]
+	if len(bolt) != 1 || *bolt[0].Identifiers.ScryfallId != "10000000-0000-4000-8000-000000000001" {
+		t.Errorf("Lightning Bolt = %+v, want its first printing in TST", bolt)
+	}
+
+	var codes []string
+	_, _, err = streamPrintings(bytes.NewReader(data), AllPrintings, func(set SetData) error {
+		codes = append(codes, set.Code)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(codes, []string{"TST", "TS2"}) {
+		t.Errorf("streamed sets %v, want TST and TS2", codes)
+	}
+
+	if _, _, err := streamPrintings(bytes.NewReader(data), Standard, nil); err == nil {
+		t.Error("streaming the printings of an atomic set succeeded")
+	}
+}
+
+func TestStreamCardsIncrementally(t *testing.T) {
+	// Write the set one card at a time, only writing the next card once the previous one has been
+	// streamed. If the decoder needed the whole set up front, this would never finish.
+	pr, pw := io.Pipe()
+	streamed := make(chan string)
+	go func() {
+		defer pw.Close()
+		io.WriteString(pw, `{"meta": {"version": "5.2.2+20261001"}, "data": {`)
+		for i, name := range []string{"Opt", "Murder", "Divination"} {
+			if i > 0 {
+				io.WriteString(pw, ",")
+			}
+			io.WriteString(pw, `"`+name+`": [{"name": "`+name+`"}]`)
+			select {
+			case got := <-streamed:
+				if got != name {
+					t.Errorf("streamed %q, want %q", got, name)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("%q wasn't streamed before the rest of the set was written", name)
+				return
+			}
+		}
+		io.WriteString(pw, "}}\n")
+	}()
+
+	_, _, err := streamCards(pr, Standard, func(name string, faces []AtomicCard) error {
+		streamed <- name
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// Embedder converts texts into vectors, such that texts with similar meanings have nearby vectors
+// (by cosine distance). Vectors are written to the "vector" attribute of each row, enabling
+// semantic and hybrid search.
+type Embedder interface {
+	// Embed returns one vector for each of texts. All vectors have the same number of dimensions.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbedderKind is an enumeration of the supported Embedder implementations.
+type EmbedderKind string
+
+// List of supported embedders.
+var (
+	// NoEmbedder writes no vectors, so only BM25 search is available.
+	NoEmbedder EmbedderKind = "none"
+
+	// HashEmbedder embeds texts locally by feature hashing, see hashEmbedder.
+	HashEmbedder EmbedderKind = "hash"
+)
+
+func (k EmbedderKind) Valid() bool {
+	switch k {
+	case NoEmbedder, HashEmbedder:
+		return true
+	default:
+		return false
+	}
+}
+
+// newEmbedder returns the Embedder of the given kind, or nil for NoEmbedder. Indexes built before
+// embedders existed have no kind, which is treated as NoEmbedder.
+func newEmbedder(kind EmbedderKind) (Embedder, error) {
+	switch kind {
+	case NoEmbedder, "":
+		return nil, nil
+	case HashEmbedder:
+		return hashEmbedder{dims: hashEmbedderDims}, nil
+	default:
+		return nil, fmt.Errorf("unknown embedder %q", kind)
+	}
+}
+
+// hashEmbedderDims is the number of dimensions of the vectors produced by the hash embedder.
+const hashEmbedderDims = 256
+
+// hashEmbedder embeds texts with the hashing trick: every word, pair of adjacent words and
+// character trigram of a word is hashed to a dimension (and a sign), and the resulting counts are
+// normalized. It's deterministic and needs no model or network access, and character trigrams
+// make it tolerant of different word forms (e.g. "drawing" and "draws"), which BM25 without
+// stemming isn't.
+type hashEmbedder struct {
+	dims int
+}
+
+func (e hashEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+func (e hashEmbedder) embed(text string) []float32 {
+	vector := make([]float32, e.dims)
+	add := func(feature string, weight float32) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// The top bit picks the sign, so that collisions cancel out on average rather than pile up.
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vector[sum%uint64(e.dims)] += weight
+	}
+
+	words := tokenize(text)
+	for i, word := range words {
+		add("w:"+word, 1)
+		if i > 0 {
+			add("b:"+words[i-1]+" "+word, 0.5)
+		}
+		padded := []rune("^" + word + "$")
+		for j := 0; j+3 <= len(padded); j++ {
+			add("t:"+string(padded[j:j+3]), 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vector {
+			vector[i] *= scale
+		}
+	}
+	return vector
+}
+
+// embeddingText returns the text of a row which is embedded: its name, type line and rules text.
+func embeddingText(row Row) string {
+	var parts []string
+	for _, attr := range []string{"face_name", "name", "type", "text"} {
+		switch v := row[attr].(type) {
+		case string:
+			parts = append(parts, v)
+		case *string:
+			if v != nil {
+				parts = append(parts, *v)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// embedRows sets the "vector" attribute of each row, embedding them in a single call.
+func embedRows(ctx context.Context, embedder Embedder, rows []Row) error {
+	texts := make([]string, len(rows))
+	for i, row := range rows {
+		texts[i] = embeddingText(row)
+	}
+	vectors, err := embedder.Embed(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("embedding %d rows: %w", len(rows), err)
+	}
+	if len(vectors) != len(rows) {
+		return fmt.Errorf("embedder returned %d vectors for %d rows", len(vectors), len(rows))
+	}
+	for i, row := range rows {
+		row["vector"] = vectors[i]
+	}
+	return nil
+}
+
+// cosineDistance returns 1 minus the cosine similarity of a and b, as turbopuffer defines it.
+// Vectors which have been through JSON are []any of float64, so both are accepted.
+func cosineDistance(a []float32, b any) (float64, bool) {
+	var other []float64
+	switch b := b.(type) {
+	case []float32:
+		for _, v := range b {
+			other = append(other, float64(v))
+		}
+	case []any:
+		for _, v := range b {
+			f, ok := v.(float64)
+			if !ok {
+				return 0, false
+			}
+			other = append(other, f)
+		}
+	default:
+		return 0, false
+	}
+	if len(other) != len(a) {
+		return 0, false
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * other[i]
+		normA += float64(a[i]) * float64(a[i])
+		normB += other[i] * other[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 1, true
+	}
+	return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB)), true
+}
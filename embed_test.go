@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestHashEmbedder(t *testing.T) {
+	embedder, err := newEmbedder(HashEmbedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{
+		"Draw two cards.",
+		"Draws a card",
+		"Destroy target creature.",
+		"",
+	}
+	vectors, err := embedder.Embed(t.Context(), texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := embedder.Embed(t.Context(), texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, vector := range vectors {
+		if len(vector) != hashEmbedderDims {
+			t.Errorf("vector for %q has %d dimensions, want %d", texts[i], len(vector), hashEmbedderDims)
+		}
+		if !slices.Equal(vector, again[i]) {
+			t.Errorf("vector for %q is not deterministic", texts[i])
+		}
+		var norm float64
+		for _, v := range vector {
+			norm += float64(v) * float64(v)
+		}
+		if want := 1.0; texts[i] == "" {
+			if norm != 0 {
+				t.Errorf("vector for empty text has norm %v, want 0", norm)
+			}
+		} else if math.Abs(norm-want) > 1e-4 {
+			t.Errorf("vector for %q has squared norm %v, want %v", texts[i], norm, want)
+		}
+	}
+
+	similar, _ := cosineDistance(vectors[0], vectors[1])
+	different, _ := cosineDistance(vectors[0], vectors[2])
+	if similar >= different {
+		t.Errorf("distance between draw texts %v is not less than to destroy text %v", similar, different)
+	}
+
+	// Vectors which have been through JSON are compared the same way.
+	asJSON := make([]any, len(vectors[1]))
+	for i, v := range vectors[1] {
+		asJSON[i] = float64(v)
+	}
+	if dist, ok := cosineDistance(vectors[0], asJSON); !ok || math.Abs(dist-similar) > 1e-6 {
+		t.Errorf("distance to JSON vector: got %v (ok: %v), want %v", dist, ok, similar)
+	}
+}
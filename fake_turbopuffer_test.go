@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/turbopuffer/turbopuffer-go"
+)
+
+// fakeTurbopuffer is a local stand-in for the turbopuffer namespace API, covering the endpoints
+// this program uses. Rows are stored and ranked by a memoryBackend, so results are BM25 ranked
+// just like the real thing (minus stemming).
+type fakeTurbopuffer struct {
+	*httptest.Server
+
+	backend *memoryBackend
+
+	mu       sync.Mutex
+	requests []fakeRequest
+}
+
+// fakeRequest is a request received by the fake, recorded for assertions.
+type fakeRequest struct {
+	Method    string
+	Namespace string
+	Endpoint  string // "write", "query", "metadata", "delete_all" or "namespaces"
+	Body      map[string]any
+}
+
+var (
+	fakeNamespacePath  = regexp.MustCompile(`^/v\d+/namespaces/([^/]+)(/query|/metadata)?$`)
+	fakeNamespacesPath = regexp.MustCompile(`^/v\d+/namespaces$`)
+)
+
+func newFakeTurbopuffer(t *testing.T) *fakeTurbopuffer {
+	t.Helper()
+	fake := &fakeTurbopuffer{backend: newMemoryBackend("")}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// Requests returns all requests received so far for the given endpoint.
+func (f *fakeTurbopuffer) Requests(endpoint string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeRequest
+	for _, req := range f.requests {
+		if req.Endpoint == endpoint {
+			out = append(out, req)
+		}
+	}
+	return out
+}
+
+// Namespaces returns the names of all namespaces currently held by the fake.
+func (f *fakeTurbopuffer) Namespaces() []string {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+	names := make([]string, 0, len(f.backend.namespaces))
+	for name := range f.backend.namespaces {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (f *fakeTurbopuffer) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeFakeError(w, http.StatusUnauthorized, "missing api key")
+		return
+	}
+	if r.Method == http.MethodGet && fakeNamespacesPath.MatchString(r.URL.Path) {
+		f.listNamespaces(w, r)
+		return
+	}
+	m := fakeNamespacePath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		writeFakeError(w, http.StatusNotFound, fmt.Sprintf("unknown path %q", r.URL.Path))
+		return
+	}
+
+	req := fakeRequest{Method: r.Method, Namespace: m[1]}
+	switch {
+	case r.Method == http.MethodPost && m[2] == "":
+		req.Endpoint = "write"
+	case r.Method == http.MethodPost && m[2] == "/query":
+		req.Endpoint = "query"
+	case r.Method == http.MethodGet && m[2] == "/metadata":
+		req.Endpoint = "metadata"
+	case r.Method == http.MethodDelete && m[2] == "":
+		req.Endpoint = "delete_all"
+	default:
+		writeFakeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		return
+	}
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req.Body); err != nil {
+			writeFakeError(w, http.StatusBadRequest, fmt.Sprintf("decoding body: %v", err))
+			return
+		}
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	var (
+		resp any
+		err  error
+	)
+	switch req.Endpoint {
+	case "write":
+		resp, err = f.write(r.Context(), req)
+	case "query":
+		resp, err = f.query(r.Context(), req)
+	case "metadata":
+		resp, err = f.metadata(r.Context(), req)
+	case "delete_all":
+		err = f.backend.DeleteAll(r.Context(), req.Namespace)
+		resp = map[string]any{"status": "ok"}
+	}
+	if errors.Is(err, ErrNamespaceNotFound) {
+		writeFakeError(w, http.StatusNotFound, fmt.Sprintf("namespace %q not found", req.Namespace))
+		return
+	} else if err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeTurbopuffer) write(ctx context.Context, req fakeRequest) (any, error) {
+	schema := make(Schema)
+	types := make(map[string]any)
+	if raw, ok := req.Body["schema"].(map[string]any); ok {
+		for attr, config := range raw {
+			config, _ := config.(map[string]any)
+			types[attr] = config["type"]
+			switch fts := config["full_text_search"].(type) {
+			case nil:
+				schema[attr] = turbopuffer.AttributeSchemaConfigParam{}
+			case bool:
+				if fts {
+					schema[attr] = turbopuffer.AttributeSchemaConfigParam{
+						FullTextSearch: &turbopuffer.FullTextSearchConfigParam{},
+					}
+				}
+			default:
+				schema[attr] = turbopuffer.AttributeSchemaConfigParam{
+					FullTextSearch: &turbopuffer.FullTextSearchConfigParam{},
+				}
+			}
+		}
+	}
+
+	raw, _ := req.Body["upsert_rows"].([]any)
+	rows := make([]Row, 0, len(raw))
+	for _, r := range raw {
+		row, ok := r.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("upsert_rows must be objects, got %T", r)
+		}
+		// Like turbopuffer, reject values which don't fit the type of their attribute.
+		for attr, value := range row {
+			if n, ok := value.(float64); ok && (types[attr] == "uint" || types[attr] == "int") && n != math.Trunc(n) {
+				return nil, fmt.Errorf("value %v of attribute %q isn't an integer", n, attr)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	var deletes []string
+	if raw, ok := req.Body["deletes"].([]any); ok {
+		for _, id := range raw {
+			deletes = append(deletes, fmt.Sprint(id))
+		}
+	}
+	// Like turbopuffer, delete_by_filter is applied before upserts, and deletes after them.
+	var filterDeletes []string
+	if raw, ok := req.Body["delete_by_filter"]; ok {
+		// Only ["id", "In", [...]] filters are supported.
+		filter, _ := raw.([]any)
+		if len(filter) != 3 || filter[0] != "id" || filter[1] != "In" {
+			return nil, fmt.Errorf("unsupported delete_by_filter %v", raw)
+		}
+		ids, _ := filter[2].([]any)
+		for _, id := range ids {
+			filterDeletes = append(filterDeletes, fmt.Sprint(id))
+		}
+		if err := f.backend.Write(ctx, req.Namespace, WriteRequest{Deletes: filterDeletes}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.backend.Write(ctx, req.Namespace, WriteRequest{
+		Upserts: rows,
+		Deletes: deletes,
+		Schema:  schema,
+	}); err != nil {
+		return nil, err
+	}
+	deleted := len(filterDeletes) + len(deletes)
+	return map[string]any{
+		"status":        "OK",
+		"message":       fmt.Sprintf("upserted %d rows, deleted %d", len(rows), deleted),
+		"rows_affected": len(rows) + deleted,
+	}, nil
+}
+
+func (f *fakeTurbopuffer) query(ctx context.Context, req fakeRequest) (any, error) {
+	var query QueryRequest
+	rankBy, _ := req.Body["rank_by"].([]any)
+	switch {
+	case len(rankBy) == 3 && rankBy[1] == "ANN":
+		// ["vector", "ANN", [...]]
+		raw, _ := rankBy[2].([]any)
+		query.Vector = make([]float32, 0, len(raw))
+		for _, v := range raw {
+			n, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("invalid rank_by: vector must be numbers, got %v", v)
+			}
+			query.Vector = append(query.Vector, float32(n))
+		}
+	case len(rankBy) == 2 && rankBy[1] == "asc":
+		// Ordering by an attribute, e.g. ["id", "asc"], is represented by a query without fields.
+	default:
+		if err := parseFakeRankBy(req.Body["rank_by"], 1, &query); err != nil {
+			return nil, fmt.Errorf("invalid rank_by: %w", err)
+		}
+	}
+	if raw, ok := req.Body["filters"]; ok && raw != nil {
+		filter, err := parseFakeFilter(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filters: %w", err)
+		}
+		query.Filters = &filter
+	}
+	if topk, ok := req.Body["top_k"].(float64); ok {
+		query.TopK = int(topk)
+	}
+	if attrs, ok := req.Body["include_attributes"].([]any); ok {
+		for _, attr := range attrs {
+			if attr, ok := attr.(string); ok {
+				query.IncludeAttributes = append(query.IncludeAttributes, attr)
+			}
+		}
+	}
+
+	rows, err := f.backend.Query(ctx, req.Namespace, query)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"rows":        rows,
+		"billing":     map[string]any{"billable_logical_bytes_queried": 0, "billable_logical_bytes_returned": 0},
+		"performance": map[string]any{"approx_namespace_size": len(rows)},
+	}, nil
+}
+
+func (f *fakeTurbopuffer) metadata(ctx context.Context, req fakeRequest) (any, error) {
+	meta, err := f.backend.Metadata(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"schema":               map[string]any{},
+		"approx_row_count":     meta.ApproxRowCount,
+		"approx_logical_bytes": meta.ApproxLogicalBytes,
+		"created_at":           meta.CreatedAt,
+		"updated_at":           meta.CreatedAt,
+	}, nil
+}
+
+// listNamespaces lists namespaces by prefix, returning them all in a single page.
+func (f *fakeTurbopuffer) listNamespaces(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{Method: r.Method, Endpoint: "namespaces"})
+	f.mu.Unlock()
+
+	names, err := f.backend.Namespaces(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeFakeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	namespaces := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		namespaces = append(namespaces, map[string]string{"id": name})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"namespaces": namespaces, "next_cursor": nil})
+}
+
+// parseFakeRankBy flattens a BM25 rank_by expression, made of Sum and Product operators over
+// ["attr", "BM25", "query"] leaves, into the weighted fields of a QueryRequest.
+func parseFakeRankBy(expr any, weight float64, query *QueryRequest) error {
+	parts, ok := expr.([]any)
+	if !ok || len(parts) < 2 {
+		return fmt.Errorf("expected an expression array, got %v", expr)
+	}
+	op, _ := parts[0].(string)
+	switch {
+	case len(parts) == 3 && parts[1] == "BM25":
+		text, _ := parts[2].(string)
+		if query.Fields != nil && query.Text != text {
+			return fmt.Errorf("mismatched query texts %q and %q", query.Text, text)
+		}
+		query.Text = text
+		query.Fields = append(query.Fields, FieldWeight{Attribute: op, Weight: weight})
+		return nil
+	case strings.EqualFold(op, "sum"):
+		subexprs, ok := parts[1].([]any)
+		if !ok {
+			return fmt.Errorf("sum expects an array of expressions, got %v", parts[1])
+		}
+		for _, sub := range subexprs {
+			if err := parseFakeRankBy(sub, weight, query); err != nil {
+				return err
+			}
+		}
+		return nil
+	case strings.EqualFold(op, "product"):
+		// Accept both ["Product", weight, expr] and ["Product", [weight, expr]], in either order.
+		args := parts[1:]
+		if len(args) == 1 {
+			args, _ = args[0].([]any)
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("product expects a weight and an expression, got %v", parts[1:])
+		}
+		if w, ok := args[0].(float64); ok {
+			return parseFakeRankBy(args[1], weight*w, query)
+		}
+		if w, ok := args[1].(float64); ok {
+			return parseFakeRankBy(args[0], weight*w, query)
+		}
+		return fmt.Errorf("product expects a numeric weight, got %v", args)
+	default:
+		return fmt.Errorf("unsupported operator %v", parts[0])
+	}
+}
+
+// parseFakeFilter parses a turbopuffer filter expression: ["And", [filters...]], ["Or",
+// [filters...]], ["Not", filter] or ["attr", "Op", value].
+func parseFakeFilter(expr any) (Filter, error) {
+	parts, ok := expr.([]any)
+	if !ok || len(parts) < 2 {
+		return Filter{}, fmt.Errorf("expected a filter array, got %v", expr)
+	}
+	if parts[0] == string(OpNot) {
+		sub, err := parseFakeFilter(parts[1])
+		if err != nil {
+			return Filter{}, err
+		}
+		return filterNot(sub), nil
+	}
+	if parts[0] == string(OpAnd) || parts[0] == string(OpOr) {
+		raw, ok := parts[1].([]any)
+		if !ok {
+			return Filter{}, fmt.Errorf("%v expects an array of filters, got %v", parts[0], parts[1])
+		}
+		filter := Filter{Op: FilterOp(parts[0].(string))}
+		for _, sub := range raw {
+			parsed, err := parseFakeFilter(sub)
+			if err != nil {
+				return Filter{}, err
+			}
+			filter.Filters = append(filter.Filters, parsed)
+		}
+		return filter, nil
+	}
+	if len(parts) != 3 {
+		return Filter{}, fmt.Errorf("expected [attr, op, value], got %v", expr)
+	}
+	attr, _ := parts[0].(string)
+	op, _ := parts[1].(string)
+	return Filter{Op: FilterOp(op), Attribute: attr, Value: parts[2]}, nil
+}
+
+func writeFakeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": msg})
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// allColors are the five colors of magic, in WUBRG order, as mtgjson abbreviates them.
+var allColors = []string{"W", "U", "B", "R", "G"}
+
+// SearchFilters narrows search results down by the structured attributes of cards. The zero value
+// matches every card. Every filter which is set must match.
+type SearchFilters struct {
+	// ColorIdentity only matches cards whose color identity is within the given colors, e.g. "GU"
+	// matches cards playable in a green-blue commander deck. "C" only matches colorless cards.
+	ColorIdentity string `json:"color_identity,omitempty"`
+
+	// ColorIdentityIncludes only matches cards whose color identity includes all the given colors.
+	ColorIdentityIncludes string `json:"color_identity_includes,omitempty"`
+
+	// Colors and ColorsIncludes are like ColorIdentity and ColorIdentityIncludes, but for the
+	// colors of the card itself.
+	Colors         string `json:"colors,omitempty"`
+	ColorsIncludes string `json:"colors_includes,omitempty"`
+
+	// ManaValue only matches cards whose mana value satisfies all the comparisons.
+	ManaValue []Comparison `json:"mana_value,omitempty"`
+
+	// Types, Subtypes and Keywords only match cards which have all the given types, subtypes and
+	// keywords respectively, e.g. "Creature", "Elf" and "Flying".
+	Types    []string `json:"types,omitempty"`
+	Subtypes []string `json:"subtypes,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+
+	// TypeLine only matches cards which have all the given types, subtypes or supertypes, e.g.
+	// "Legendary", "Creature" or "Elf".
+	TypeLine []string `json:"type_line,omitempty"`
+
+	// Text only matches cards whose rules text contains all the words of each of the given
+	// phrases, e.g. "draw a card".
+	Text []string `json:"text,omitempty"`
+
+	// Formats only matches cards which are legal (or restricted) in all the given formats.
+	Formats []string `json:"formats,omitempty"`
+
+	// Power and Toughness only match cards with a numeric power or toughness satisfying all the
+	// comparisons.
+	Power     []Comparison `json:"power,omitempty"`
+	Toughness []Comparison `json:"toughness,omitempty"`
+
+	// Reserved, if set, only matches cards which are (or aren't) on the reserved list.
+	Reserved *bool `json:"reserved,omitempty"`
+
+	// Exclude matches no cards which match any of the given filters.
+	Exclude []SearchFilters `json:"exclude,omitempty"`
+}
+
+// Comparison compares a numeric attribute against a value, e.g. "<= 3".
+type Comparison struct {
+	Op    FilterOp `json:"op"`
+	Value float64  `json:"value"`
+}
+
+// comparisonSymbols maps comparison operators, as written by users, to filter operators.
+var comparisonSymbols = map[string]FilterOp{
+	"<":  OpLt,
+	"<=": OpLte,
+	">":  OpGt,
+	">=": OpGte,
+	"=":  OpEq,
+}
+
+// parseComparison parses a comparison such as "<=3", ">2.5" or "4" (meaning "=4").
+func parseComparison(s string) (Comparison, error) {
+	op, rest := OpEq, s
+	for _, symbol := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(s, symbol) {
+			op, rest = comparisonSymbols[symbol], s[len(symbol):]
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(rest), 64)
+	if err != nil {
+		return Comparison{}, fmt.Errorf("invalid comparison %q, expected e.g. <=3, >2 or 4", s)
+	}
+	return Comparison{Op: op, Value: value}, nil
+}
+
+// parseColors validates a set of colors such as "gu" or "C", returning them uppercased.
+func parseColors(s string) (string, error) {
+	s = strings.ToUpper(s)
+	if s == "C" {
+		return s, nil
+	}
+	for _, r := range s {
+		if !slices.Contains(allColors, string(r)) {
+			return "", fmt.Errorf("invalid colors %q, expected a combination of W, U, B, R, G or C", s)
+		}
+	}
+	return s, nil
+}
+
+// Empty reports whether no filters are set.
+func (f SearchFilters) Empty() bool {
+	return f.ColorIdentity == "" &&
+		f.ColorIdentityIncludes == "" &&
+		f.Colors == "" &&
+		f.ColorsIncludes == "" &&
+		len(f.ManaValue) == 0 &&
+		len(f.Types) == 0 &&
+		len(f.Subtypes) == 0 &&
+		len(f.Keywords) == 0 &&
+		len(f.TypeLine) == 0 &&
+		len(f.Text) == 0 &&
+		len(f.Formats) == 0 &&
+		len(f.Power) == 0 &&
+		len(f.Toughness) == 0 &&
+		f.Reserved == nil &&
+		len(f.Exclude) == 0
+}
+
+// And returns filters which match only the cards matched by both f and g.
+func (f SearchFilters) And(g SearchFilters) (SearchFilters, error) {
+	if f.Reserved != nil && g.Reserved != nil && *f.Reserved != *g.Reserved {
+		return SearchFilters{}, errors.New("cards can't both be and not be on the reserved list")
+	}
+	merged := SearchFilters{
+		ColorIdentity:         intersectColors(f.ColorIdentity, g.ColorIdentity),
+		ColorIdentityIncludes: unionColors(f.ColorIdentityIncludes, g.ColorIdentityIncludes),
+		Colors:                intersectColors(f.Colors, g.Colors),
+		ColorsIncludes:        unionColors(f.ColorsIncludes, g.ColorsIncludes),
+		ManaValue:             slices.Concat(f.ManaValue, g.ManaValue),
+		Types:                 slices.Concat(f.Types, g.Types),
+		Subtypes:              slices.Concat(f.Subtypes, g.Subtypes),
+		Keywords:              slices.Concat(f.Keywords, g.Keywords),
+		TypeLine:              slices.Concat(f.TypeLine, g.TypeLine),
+		Text:                  slices.Concat(f.Text, g.Text),
+		Formats:               slices.Concat(f.Formats, g.Formats),
+		Power:                 slices.Concat(f.Power, g.Power),
+		Toughness:             slices.Concat(f.Toughness, g.Toughness),
+		Reserved:              f.Reserved,
+		Exclude:               slices.Concat(f.Exclude, g.Exclude),
+	}
+	if merged.Reserved == nil {
+		merged.Reserved = g.Reserved
+	}
+	return merged, nil
+}
+
+// intersectColors returns the colors in both a and b, where "" means any colors and "C" means
+// none. Used to combine "within these colors" filters.
+func intersectColors(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	var colors string
+	for _, color := range allColors {
+		if strings.Contains(a, color) && strings.Contains(b, color) {
+			colors += color
+		}
+	}
+	if colors == "" {
+		return "C"
+	}
+	return colors
+}
+
+// unionColors returns the colors in either a or b, in WUBRG order. Used to combine "includes
+// these colors" filters.
+func unionColors(a, b string) string {
+	var colors string
+	for _, color := range allColors {
+		if strings.Contains(a, color) || strings.Contains(b, color) {
+			colors += color
+		}
+	}
+	return colors
+}
+
+// Compile converts the filters into a Filter over the attributes written by buildRow. Returns nil
+// if no filters are set.
+func (f SearchFilters) Compile() *Filter {
+	var filters []Filter
+
+	filters = append(filters, colorFilters("color_identity", f.ColorIdentity, f.ColorIdentityIncludes)...)
+	filters = append(filters, colorFilters("colors", f.Colors, f.ColorsIncludes)...)
+	for _, c := range f.ManaValue {
+		filters = append(filters, filterCompare("converted_mana_cost", c.Op, c.Value))
+	}
+	for _, t := range f.Types {
+		filters = append(filters, filterContains("types", capitalize(t)))
+	}
+	for _, t := range f.Subtypes {
+		filters = append(filters, filterContains("subtypes", capitalizeWords(t)))
+	}
+	for _, k := range f.Keywords {
+		filters = append(filters, filterContains("keywords", capitalize(k)))
+	}
+	for _, t := range f.TypeLine {
+		filters = append(filters, filterOr(
+			filterContains("types", capitalize(t)),
+			filterContains("subtypes", capitalizeWords(t)),
+			filterContains("supertypes", capitalize(t)),
+		))
+	}
+	for _, text := range f.Text {
+		filters = append(filters, filterContainsAllTokens("text", text))
+	}
+	for _, format := range f.Formats {
+		format = strings.ToLower(format)
+		filters = append(filters, filterOr(
+			filterContains("legal_formats", format),
+			filterContains("restricted_formats", format),
+		))
+	}
+	for _, c := range f.Power {
+		filters = append(filters, filterCompare("power_value", c.Op, c.Value))
+	}
+	for _, c := range f.Toughness {
+		filters = append(filters, filterCompare("toughness_value", c.Op, c.Value))
+	}
+	if f.Reserved != nil {
+		filters = append(filters, filterEq("is_reserved", *f.Reserved))
+	}
+	for _, exclude := range f.Exclude {
+		if compiled := exclude.Compile(); compiled != nil {
+			filters = append(filters, filterNot(*compiled))
+		}
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return &filters[0]
+	default:
+		filter := filterAnd(filters...)
+		return &filter
+	}
+}
+
+// colorFilters returns the filters matching cards whose colors (in attr) are within the colors of
+// within, and include all the colors of includes.
+func colorFilters(attr, within, includes string) []Filter {
+	var filters []Filter
+	if within != "" {
+		var excluded []string
+		for _, color := range allColors {
+			if !strings.Contains(within, color) {
+				excluded = append(excluded, color)
+			}
+		}
+		if len(excluded) > 0 {
+			filters = append(filters, filterNot(filterContainsAny(attr, excluded)))
+		}
+	}
+	for _, color := range includes {
+		if color != 'C' {
+			filters = append(filters, filterContains(attr, string(color)))
+		}
+	}
+	return filters
+}
+
+// filtersFromParams parses filters from query parameters, as accepted by the HTTP API:
+//
+//	identity=GU identity_includes=G mv=<=3 type=creature subtype=elf keyword=flying
+//	format=pauper power=>=2 toughness=<4 reserved=false
+//
+// All parameters but identity, identity_includes and reserved may be repeated.
+func filtersFromParams(params url.Values) (SearchFilters, error) {
+	var (
+		filters SearchFilters
+		err     error
+	)
+	if v := params.Get("identity"); v != "" {
+		if filters.ColorIdentity, err = parseColors(v); err != nil {
+			return SearchFilters{}, fmt.Errorf("identity: %w", err)
+		}
+	}
+	if v := params.Get("identity_includes"); v != "" {
+		if filters.ColorIdentityIncludes, err = parseColors(v); err != nil {
+			return SearchFilters{}, fmt.Errorf("identity_includes: %w", err)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *[]Comparison
+	}{
+		{"mv", &filters.ManaValue},
+		{"power", &filters.Power},
+		{"toughness", &filters.Toughness},
+	} {
+		for _, v := range params[p.name] {
+			c, err := parseComparison(v)
+			if err != nil {
+				return SearchFilters{}, fmt.Errorf("%s: %w", p.name, err)
+			}
+			*p.dst = append(*p.dst, c)
+		}
+	}
+	filters.Types = params["type"]
+	filters.Subtypes = params["subtype"]
+	filters.Keywords = params["keyword"]
+	filters.Formats = params["format"]
+	if v := params.Get("reserved"); v != "" {
+		reserved, err := strconv.ParseBool(v)
+		if err != nil {
+			return SearchFilters{}, errors.New("reserved: must be true or false")
+		}
+		filters.Reserved = &reserved
+	}
+	return filters, nil
+}
+
+// capitalize uppercases the first letter of s, matching how mtgjson writes types and keywords
+// (e.g. "Creature", "First strike").
+func capitalize(s string) string {
+	s = strings.ToLower(s)
+	for i, r := range s {
+		return s[:i] + string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
+
+// capitalizeWords uppercases the first letter of every word of s, matching how mtgjson writes
+// subtypes (e.g. "Time Lord").
+func capitalizeWords(s string) string {
+	words := strings.Fields(s)
+	for i, word := range words {
+		words[i] = capitalize(word)
+	}
+	return strings.Join(words, " ")
+}
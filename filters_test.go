@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestSearchFilters(t *testing.T) {
+	fixture, _ := loadFixture(t)
+	backend := newMemoryBackend("")
+	index := &Index{Name: "cards", Namespace: "mtg_cards"}
+	if _, err := upsertSet(t.Context(), backend, index.Namespace, atomicEntries(fixture), nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		params string
+		want   []string
+	}{
+		{
+			name:   "green pauper creatures",
+			params: "identity=g&type=creature&format=pauper&mv=<=3",
+			want:   []string{"Llanowar Elves"},
+		},
+		{
+			name:   "landfall in modern",
+			query:  "landfall",
+			params: "identity=g&type=creature&format=modern&mv=<=3",
+			want:   []string{"Lotus Cobra"},
+		},
+		{
+			name:   "landfall in pauper",
+			query:  "landfall",
+			params: "format=pauper",
+			want:   nil,
+		},
+		{
+			name:   "identity excludes other colors",
+			query:  "draw",
+			params: "identity=u",
+			want:   []string{"Divination", "Opt"},
+		},
+		{
+			name:   "identity includes",
+			params: "identity_includes=ur",
+			want:   []string{"Fire // Ice"},
+		},
+		{
+			name:   "reserved list",
+			params: "reserved=true",
+			want:   []string{"Mox Diamond"},
+		},
+		{
+			name:   "power comparison matches any face",
+			params: "power=>=3",
+			want:   []string{"Delver of Secrets // Insectile Aberration"},
+		},
+		{
+			name:   "keywords and subtypes",
+			params: "keyword=flying&subtype=insect",
+			want:   []string{"Delver of Secrets // Insectile Aberration"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filters, err := filtersFromParams(params)
+			if err != nil {
+				t.Fatalf("parsing %q: %v", tc.params, err)
+			}
+			results, err := index.Search(t.Context(), backend, SearchRequest{
+				Query:   tc.query,
+				Filters: filters,
+				TopK:    10,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, result := range results {
+				got = append(got, result.Name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFiltersFromParamsErrors(t *testing.T) {
+	for _, params := range []string{
+		"identity=gx",
+		"mv=<=three",
+		"power=~2",
+		"reserved=maybe",
+	} {
+		values, err := url.ParseQuery(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := filtersFromParams(values); err == nil {
+			t.Errorf("parsing %q succeeded, want an error", params)
+		}
+	}
+}
+
+func TestHalfManaValue(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			backend, _ := newTestBackend(t, kind)
+			fixture, _ := loadFixture(t)
+
+			// Un-cards such as Little Girl have half mana values, which must be stored as is.
+			girl := fixture.Data["Llanowar Elves"][0]
+			girl.Name, girl.ManaValue, girl.ConvertedManaCost = "Little Girl", 0.5, 0.5
+			girl.Identifiers.ScryfallOracleId = nil
+			fixture.Data = map[string][]AtomicCard{"Little Girl": {girl}, "Opt": fixture.Data["Opt"]}
+			index := &Index{Name: "cards", Namespace: "mtg_cards"}
+			if _, err := upsertSet(t.Context(), backend, index.Namespace, atomicEntries(fixture), nil, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			for query, want := range map[string][]string{
+				"mv>0 mv<1": {"Little Girl"},
+				"mv=0.5":    {"Little Girl"},
+				"mv>=1":     {"Opt"},
+			} {
+				parsed, err := ParseQuery(query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				results, err := index.Search(t.Context(), backend, SearchRequest{Filters: parsed.Filters, TopK: 10})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, result := range results {
+					got = append(got, result.Name)
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("%s: got %v, want %v", query, got, want)
+				}
+			}
+		})
+	}
+}
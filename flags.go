@@ -1,41 +1,143 @@
 package main
 
 import (
+	"cmp"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 )
 
+// allFlags holds every flag, whichever commands accept it. Each command parses its arguments with a
+// flag set of its own, which shares the values of the flags it accepts (see command.flagSet), so
+// the settings below are set wherever they were parsed.
+var allFlags = flag.NewFlagSet("puffingmtg", flag.ContinueOnError)
+
 var (
-	flagTpufRegion = flag.String(
+	flagConfig = allFlags.String(
+		"config",
+		"",
+		"config file to read settings from (.toml, .yaml, .yml). default $PUFFINGMTG_CONFIG, or config.toml in $XDG_CONFIG_HOME/puffingmtg (or ~/.config/puffingmtg) if it exists",
+	)
+	flagConfigProfile = allFlags.String(
+		"config-profile",
+		"",
+		"profile of the config file to use, e.g. staging or prod (default $PUFFINGMTG_CONFIG_PROFILE)",
+	)
+	flagTpufRegion = allFlags.String(
 		"tpuf-region",
 		"",
-		"turbopuffer region (see: turbopuffer.com/docs/regions; pick the one closest to you)",
+		"turbopuffer region (see: turbopuffer.com/docs/regions; pick the one closest to you). default $TURBOPUFFER_REGION",
 	)
-	flagTpufApiKey = flag.String(
+	flagTpufApiKey = allFlags.String(
 		"tpuf-api-key",
 		"",
-		"your turbopuffer API key",
+		"your turbopuffer API key. prefer $TURBOPUFFER_API_KEY or the config file, which don't leak it into shell history and process lists",
 	)
-	flagBuildIndex = flag.String(
-		"build-index",
+	flagTpufBaseURL = allFlags.String(
+		"tpuf-base-url",
 		"",
-		"name of the index to build. will create a json file with this name in current directory",
+		"turbopuffer API base URL, e.g. to point at a local fake (overrides -tpuf-region)",
+	)
+	flagBackend = allFlags.String(
+		"backend",
+		"turbopuffer",
+		"where to store indexes (turbopuffer, memory). memory needs no network or api key",
 	)
-	flagDeleteIndex = flag.String(
-		"delete-index",
+	flagMemoryDir = allFlags.String(
+		"memory-dir",
 		"",
-		"name of the index to delete both locally and from turbopuffer",
+		"directory in which the memory backend persists its namespaces (default $XDG_DATA_HOME/puffingmtg/memory, or ~/.local/share/puffingmtg/memory)",
 	)
-	flagServeIndex = flag.String(
-		"serve-index",
+	flagIndexDir = allFlags.String(
+		"index-dir",
 		"",
-		"name of the index to serve via an HTTP server",
+		"directory holding index files (default $XDG_DATA_HOME/puffingmtg/indexes, or ~/.local/share/puffingmtg/indexes)",
+	)
+	flagYes = allFlags.Bool(
+		"yes",
+		false,
+		"delete without asking for confirmation",
+	)
+	flagFormat = allFlags.String(
+		"format",
+		"table",
+		"how to write search results: table, json (an array of objects), jsonl (an object per line) or csv",
+	)
+	flagFields = allFlags.String(
+		"fields",
+		"",
+		"comma-separated fields of search results to write: rank, score, name, card_id, printing_id, layout, or any attribute of the index (e.g. edhrec_rank). default name,mana_cost,type,score, with set_code,number for printing indexes",
+	)
+	flagTopK = allFlags.Int(
+		"k",
+		defaultSearchTopK,
+		"how many results to return, at most 100",
+	)
+	flagSearchMode = allFlags.String(
+		"mode",
+		"",
+		"how to match the query: bm25, vector or hybrid. default hybrid for indexes with vectors, otherwise bm25",
+	)
+	flagLang = allFlags.String(
+		"lang",
+		"",
+		"code of the language the query is in, e.g. de. only supported by bm25 search",
+	)
+	flagCollapse = allFlags.Bool(
+		"collapse",
+		false,
+		"for printing indexes, return only the best matching printing of each card",
+	)
+	flagRankingProfile = allFlags.String(
+		"profile",
+		"",
+		"ranking profile, weighing the fields matched by the query: default, rules, popular, or one from the config file",
+	)
+	flagListen = allFlags.String(
+		"listen",
+		"localhost:8080",
+		"address for the HTTP server to listen on",
+	)
+	flagEmbedder = allFlags.String(
+		"embedder",
+		"hash",
+		"how to embed cards for vector and hybrid search (hash, none). hash runs locally",
+	)
+	flagIndexMode = allFlags.String(
+		"index-mode",
+		"cards",
+		"what each row is: cards (one per card), or printings (one per printing, needs -set allprintings or a set code)",
 	)
-	flagSet = flag.String(
+	flagSet = allFlags.String(
 		"set",
 		"",
-		"which mtgjson set to download and index (vintage, standard, pioneer, pauper, modern)",
+		"which mtgjson set to download and index: all, vintage, legacy, modern, pioneer, standard, pauper, allprintings (every printing of every card), or a set code (e.g. MH3) for just the cards in that set",
+	)
+	flagSource = allFlags.String(
+		"source",
+		"",
+		"read the set from this local mtgjson file (.json, .json.gz, .json.xz, .json.bz2) or directory instead of downloading it",
+	)
+	flagUploadConcurrency = allFlags.Int(
+		"upload-concurrency",
+		4,
+		"how many batches of rows to write to the backend at once",
+	)
+	flagUploadBatchSize = allFlags.Int(
+		"upload-batch-size",
+		32,
+		"the size of each batch of rows written to the backend, in MB of JSON (at most 256, turbopuffer's limit)",
+	)
+	flagUploadRetries = allFlags.Int(
+		"upload-retries",
+		5,
+		"how many times to retry a batch which failed with a transient error (e.g. rate limiting), backing off exponentially",
 	)
 )
 
@@ -43,64 +145,220 @@ func tpufApiKey() (string, error) {
 	if *flagTpufApiKey != "" {
 		return *flagTpufApiKey, nil
 	}
-	return "", errors.New("missing --tpuf-api-key flag")
+	return "", errors.New(
+		"missing turbopuffer api key, set $TURBOPUFFER_API_KEY, tpuf-api-key in the config file, or -tpuf-api-key",
+	)
 }
 
 func tpufRegion() string {
 	if *flagTpufRegion != "" {
 		return *flagTpufRegion
 	}
-	log.Println("no --tpuf-region flag provided, defaulting to gcp-us-central1")
+	log.Println("no turbopuffer region configured (-tpuf-region or $TURBOPUFFER_REGION), defaulting to gcp-us-central1")
 	return "gcp-us-central1"
 }
 
+// indexDir returns the directory holding index files: -index-dir if set, otherwise the
+// puffingmtg/indexes directory under the XDG data directory.
+func indexDir() string {
+	return cmp.Or(*flagIndexDir, dataDir("indexes"))
+}
+
+// memoryDir returns the directory in which the memory backend persists its namespaces:
+// -memory-dir if set, otherwise the puffingmtg/memory directory under the XDG data directory. Like
+// index files, namespaces don't depend on the current directory.
+func memoryDir() string {
+	return cmp.Or(*flagMemoryDir, dataDir("memory"))
+}
+
+// dataDir returns the directory name under puffingmtg in the XDG data directory. If no home
+// directory can be found, falls back to the current directory.
+func dataDir(name string) string {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+		return filepath.Join(dataHome, "puffingmtg", name)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".local", "share", "puffingmtg", name)
+}
+
+func backendKind() (BackendKind, error) {
+	kind := BackendKind(*flagBackend)
+	if !kind.Valid() {
+		return "", errors.New("invalid backend, must be one of turbopuffer, memory")
+	}
+	return kind, nil
+}
+
+func embedderKind() (EmbedderKind, error) {
+	kind := EmbedderKind(*flagEmbedder)
+	if !kind.Valid() {
+		return "", errors.New("invalid embedder, must be one of hash, none")
+	}
+	return kind, nil
+}
+
+func indexMode() (IndexMode, error) {
+	mode := IndexMode(*flagIndexMode)
+	if !mode.Valid() {
+		return "", errors.New("invalid index mode, must be one of cards, printings")
+	}
+	return mode, nil
+}
+
+func uploadConcurrency() (int, error) {
+	if *flagUploadConcurrency < 1 {
+		return 0, errors.New("invalid upload concurrency, must be at least 1")
+	}
+	return *flagUploadConcurrency, nil
+}
+
+// uploadBatchBytes returns the target size of each batch of rows written to the backend, in bytes.
+func uploadBatchBytes() (int, error) {
+	if *flagUploadBatchSize < 1 || *flagUploadBatchSize > maxWriteBytes>>20 {
+		return 0, fmt.Errorf("invalid upload batch size, must be between 1 and %d MB", maxWriteBytes>>20)
+	}
+	return *flagUploadBatchSize << 20, nil
+}
+
+func uploadRetries() (int, error) {
+	if *flagUploadRetries < 0 {
+		return 0, errors.New("invalid upload retries, must not be negative")
+	}
+	return *flagUploadRetries, nil
+}
+
+func outputFormat() (OutputFormat, error) {
+	format := OutputFormat(*flagFormat)
+	if !format.Valid() {
+		return "", errors.New("invalid output format, must be one of table, json, jsonl, csv")
+	}
+	return format, nil
+}
+
+func searchTopK() (int, error) {
+	if *flagTopK < 1 || *flagTopK > maxSearchTopK {
+		return 0, fmt.Errorf("invalid k, must be between 1 and %d", maxSearchTopK)
+	}
+	return *flagTopK, nil
+}
+
+// searchFields returns the fields of search results to write for an index: -fields, or the
+// default fields of the index.
+func searchFields(index *Index) ([]string, error) {
+	if *flagFields == "" {
+		if index.Mode == PrintingMode {
+			return defaultPrintingFields, nil
+		}
+		return defaultFields, nil
+	}
+	attrs := index.Attributes()
+	fields := strings.Split(*flagFields, ",")
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+		if !slices.Contains(resultFields, fields[i]) && !slices.Contains(attrs, fields[i]) {
+			return nil, fmt.Errorf(
+				"invalid field %q, must be one of %s, or an attribute of index %q: %s",
+				fields[i],
+				strings.Join(resultFields, ", "),
+				index.Name,
+				strings.Join(attrs, ", "),
+			)
+		}
+	}
+	return fields, nil
+}
+
 func mtgSet() (Set, error) {
+	// Set codes are accepted in any case, but the atomic sets take precedence: "all" is every card,
+	// while "ALL" is Alliances.
 	set := Set(*flagSet)
+	if !set.Valid() {
+		set = Set(strings.ToUpper(*flagSet))
+	}
 	if !set.Valid() {
 		return "", errors.New(
-			"invalid set, must be one of vintage, standard, pioneer, pauper, modern",
+			"invalid set, must be one of all, vintage, legacy, modern, pioneer, standard, pauper, allprintings or an mtgjson set code (e.g. MH3)",
 		)
 	}
 	return set, nil
 }
 
-// Set is an enumeration of supported mtgjson MTG sets.
+// Set is an enumeration of supported mtgjson MTG sets: either one of the atomic files mtgjson
+// publishes, or an individual set by its code (e.g. "MH3").
 type Set string
 
-// List of supported sets. Notably, we care about the atomic version of the set.
+// List of supported atomic sets, i.e. unique cards only, ignoring reprints and variations.
 var (
+	All      Set = "all"
 	Vintage  Set = "vintage"
-	Standard Set = "standard"
+	Legacy   Set = "legacy"
+	Modern   Set = "modern"
 	Pioneer  Set = "pioneer"
+	Standard Set = "standard"
 	Pauper   Set = "pauper"
-	Modern   Set = "modern"
 )
 
+// AllPrintings is every printing of every card. Unlike the atomic sets, it includes reprints, so
+// it can back printing indexes.
+var AllPrintings Set = "allprintings"
+
+// setCodePattern matches mtgjson set codes. Set codes are uppercase, to tell them apart from the
+// atomic sets. Codes which are reserved filenames on Windows have a trailing underscore in mtgjson
+// (e.g. "CON_").
+var setCodePattern = regexp.MustCompile(`^[0-9A-Z]{2,6}_?$`)
+
+// mtgjsonBaseURL is the base URL that sets are downloaded from.
+var mtgjsonBaseURL = "https://mtgjson.com/api/v5"
+
 // DownloadURL returns the mtgjson download URL for the given set.
-// Specifically, downloads the atomic version of the set, i.e. unique cards only,
-// ignoring reprints and variations.
 func (s Set) DownloadURL() (string, error) {
 	switch s {
+	case All:
+		return mtgjsonBaseURL + "/AtomicCards.json", nil
 	case Vintage:
-		return "https://mtgjson.com/api/v5/LegacyAtomic.json", nil
-	case Standard:
-		return "https://mtgjson.com/api/v5/StandardAtomic.json", nil
+		return mtgjsonBaseURL + "/VintageAtomic.json", nil
+	case Legacy:
+		return mtgjsonBaseURL + "/LegacyAtomic.json", nil
+	case Modern:
+		return mtgjsonBaseURL + "/ModernAtomic.json", nil
 	case Pioneer:
-		return "https://mtgjson.com/api/v5/PioneerAtomic.json", nil
+		return mtgjsonBaseURL + "/PioneerAtomic.json", nil
+	case Standard:
+		return mtgjsonBaseURL + "/StandardAtomic.json", nil
 	case Pauper:
-		return "https://mtgjson.com/api/v5/PauperAtomic.json", nil
-	case Modern:
-		return "https://mtgjson.com/api/v5/ModernAtomic.json", nil
-	default:
-		return "", errors.New("unknown set")
+		return mtgjsonBaseURL + "/PauperAtomic.json", nil
+	case AllPrintings:
+		return mtgjsonBaseURL + "/AllPrintings.json", nil
+	}
+	if code, ok := s.Code(); ok {
+		return mtgjsonBaseURL + "/" + code + ".json", nil
 	}
+	return "", errors.New("unknown set")
+}
+
+// Code returns the set code of an individual set, and whether the set is one.
+func (s Set) Code() (string, bool) {
+	if !setCodePattern.MatchString(string(s)) {
+		return "", false
+	}
+	return string(s), true
+}
+
+// HasPrintings returns whether the set lists every printing of its cards, rather than only unique
+// cards.
+func (s Set) HasPrintings() bool {
+	_, ok := s.Code()
+	return ok || s == AllPrintings
 }
 
 func (s Set) Valid() bool {
 	switch s {
-	case Vintage, Standard, Pioneer, Pauper, Modern:
+	case All, Vintage, Legacy, Modern, Pioneer, Standard, Pauper, AllPrintings:
 		return true
-	default:
-		return false
 	}
+	_, ok := s.Code()
+	return ok
 }
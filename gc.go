@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// OrphanedNamespace is a namespace created for an index which no local index file refers to, e.g.
+// because the index file was lost, or a build died without cleaning up after itself.
+type OrphanedNamespace struct {
+	Name     string
+	Metadata NamespaceMetadata
+}
+
+// findOrphanedNamespaces lists every index namespace in the backend, and returns those which
+// aren't referred to by an index in the index directory. Namespaces of indexes which are being
+// built (or whose build was interrupted) aren't orphaned, as their staging file refers to them.
+// If any index file can't be read, the namespace it refers to can't be known, so rather than
+// treating it as orphaned, this returns an error.
+func findOrphanedNamespaces(ctx context.Context, backend Backend) ([]OrphanedNamespace, error) {
+	legacy, err := legacyIndexFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(legacy) > 0 {
+		return nil, fmt.Errorf(
+			"index files %s are in the current directory, and their namespaces would be taken to be "+
+				"orphaned; move them to %q first",
+			strings.Join(legacy, ", "),
+			indexDir(),
+		)
+	}
+	indexes, err := readIndexFiles(true)
+	if err != nil {
+		return nil, fmt.Errorf("%w; fix or remove it, so its namespace isn't taken to be orphaned", err)
+	}
+	referenced := make(map[string]bool, len(indexes))
+	for _, index := range indexes {
+		referenced[index.Namespace] = true
+	}
+
+	namespaces, err := backend.Namespaces(ctx, namespacePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+	var orphans []OrphanedNamespace
+	for _, namespace := range namespaces {
+		if referenced[namespace] {
+			continue
+		}
+		meta, err := backend.Metadata(ctx, namespace)
+		if errors.Is(err, ErrNamespaceNotFound) {
+			// Deleted since it was listed.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("getting metadata of namespace %q: %w", namespace, err)
+		}
+		orphans = append(orphans, OrphanedNamespace{Name: namespace, Metadata: *meta})
+	}
+	return orphans, nil
+}
+
+// confirmInput is where answers to confirmation prompts are read from.
+var confirmInput io.Reader = os.Stdin
+
+// confirm asks the user a yes/no question on stdin, defaulting to no.
+func confirm(prompt string) (bool, error) {
+	fmt.Printf("%s [y/N] ", prompt)
+	answer, err := bufio.NewReader(confirmInput).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("reading answer from stdin: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// formatBytes formats a size in bytes for humans, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGCNamespaces(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			serveFixtures(t)
+			backend, _ := newTestBackend(t, kind)
+			setFlag(t, flagSet, string(Standard))
+			t.Chdir(t.TempDir())
+
+			ctx := t.Context()
+			if err := buildIndex(ctx, backend, "cards"); err != nil {
+				t.Fatalf("building index: %v", err)
+			}
+			index, err := LoadIndex("cards")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const (
+				orphan  = "mtg_lost_20261016-000000_deadbeef"
+				staged  = "mtg_building_20261016-000000_cafef00d"
+				foreign = "someone_elses_namespace"
+			)
+			for _, namespace := range []string{orphan, staged, foreign} {
+				if err := backend.Write(ctx, namespace, WriteRequest{Upserts: []Row{{"id": "1"}}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			building := &Index{Name: "building", Namespace: staged, State: IndexBuilding}
+			if err := building.writeFile(stagingFilepath("building")); err != nil {
+				t.Fatal(err)
+			}
+
+			orphans, err := findOrphanedNamespaces(ctx, backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(orphans) != 1 || orphans[0].Name != orphan || orphans[0].Metadata.ApproxRowCount != 1 {
+				t.Fatalf("got orphans %+v, want only %q with 1 row", orphans, orphan)
+			}
+
+			exists := func(namespace string) bool {
+				t.Helper()
+				_, err := backend.Metadata(ctx, namespace)
+				if err != nil && !errors.Is(err, ErrNamespaceNotFound) {
+					t.Fatal(err)
+				}
+				return err == nil
+			}
+
+			// Declining the prompt deletes nothing.
+			setFlag[io.Reader](t, &confirmInput, strings.NewReader("n\n"))
+			if err := gcNamespaces(ctx, backend); err != nil {
+				t.Fatalf("gc: %v", err)
+			}
+			if !exists(orphan) {
+				t.Fatal("declined gc deleted the orphaned namespace")
+			}
+
+			setFlag[io.Reader](t, &confirmInput, strings.NewReader("y\n"))
+			if err := gcNamespaces(ctx, backend); err != nil {
+				t.Fatalf("gc: %v", err)
+			}
+			if exists(orphan) {
+				t.Error("gc didn't delete the orphaned namespace")
+			}
+			for _, namespace := range []string{index.Namespace, staged, foreign} {
+				if !exists(namespace) {
+					t.Errorf("gc deleted namespace %q, which isn't orphaned", namespace)
+				}
+			}
+
+			// With -yes, there's no prompt.
+			if err := backend.Write(ctx, orphan, WriteRequest{Upserts: []Row{{"id": "1"}}}); err != nil {
+				t.Fatal(err)
+			}
+			setFlag(t, flagYes, true)
+			setFlag[io.Reader](t, &confirmInput, strings.NewReader(""))
+			if err := gcNamespaces(ctx, backend); err != nil {
+				t.Fatalf("gc: %v", err)
+			}
+			if exists(orphan) {
+				t.Error("gc -yes didn't delete the orphaned namespace")
+			}
+
+			namespaces, err := backend.Namespaces(ctx, namespacePrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{staged, index.Namespace}; !slices.Equal(namespaces, want) {
+				t.Errorf("namespaces after gc: got %v, want %v", namespaces, want)
+			}
+
+			// An index file which can't be parsed might refer to any namespace, so gc stops.
+			if err := os.WriteFile(indexFilepath("broken"), []byte(`{"name": "broken", "namesp`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := backend.Write(ctx, orphan, WriteRequest{Upserts: []Row{{"id": "1"}}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := gcNamespaces(ctx, backend); err == nil {
+				t.Error("gc with a broken index file succeeded")
+			}
+			if !exists(orphan) {
+				t.Error("gc with a broken index file deleted a namespace")
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3<<30 + 1<<29: "3.5 GiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -3,8 +3,11 @@ module github.com/morgangallant/puffingmtg
 go 1.25.0
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/google/uuid v1.6.0
 	github.com/turbopuffer/turbopuffer-go v1.0.0
+	github.com/ulikunitz/xz v0.5.15
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -9,8 +10,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,12 +43,163 @@ type Index struct {
 
 	// The set that was indexed.
 	Set Set `json:"set"`
+
+	// Mode is what the rows of the index represent: cards, or printings of cards.
+	Mode IndexMode `json:"mode,omitempty"`
+
+	// Version is the mtgjson version of the source file, e.g. "5.2.2+20250830".
+	Version string `json:"version,omitempty"`
+
+	// Embedder is the embedder used to write the vector of every row, if any. Queries must be
+	// embedded with the same embedder.
+	Embedder EmbedderKind `json:"embedder,omitempty"`
+
+	// State is whether the index is still being built, or ready to be served. Index files written
+	// before states were recorded have no state, and are ready.
+	State IndexState `json:"state,omitempty"`
+
+	// UpdatedAt is the timestamp of when the index was last refreshed, if ever.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+
+	// Cards records, for each card name in the set (or each printing, see printingKey, in printing
+	// indexes), what was written for it. Used to refresh the index in place, only touching the cards
+	// which changed.
+	Cards map[string]IndexedCard `json:"cards,omitempty"`
+}
+
+// IndexedCard describes the rows written for a single card.
+type IndexedCard struct {
+	// Checksum is the hex SHA256 of the card's faces, as JSON.
+	Checksum string `json:"checksum"`
+
+	// IDs are the row IDs of the card's faces, in the order they appear in the set.
+	IDs []string `json:"ids"`
 }
 
+// IndexState is an enumeration of the states an index can be in.
+type IndexState string
+
+// List of index states.
+var (
+	// IndexBuilding indexes are being uploaded to their namespace. Their metadata only exists in a
+	// staging file, see stagingFilepath, so an interrupted build can be found and cleaned up.
+	IndexBuilding IndexState = "building"
+
+	// IndexReady indexes have been fully uploaded, and can be served.
+	IndexReady IndexState = "ready"
+)
+
+// cleanupTimeout bounds how long cleaning up after a failed build may take. The cleanup runs even
+// if the build was interrupted, so it can't use the (cancelled) context of the build.
+const cleanupTimeout = 30 * time.Second
+
 // LoadIndex loads an Index with the given name. If the index doesn't exist, returns nil.
-// The index is expected to be in a file named <name>.json in the current directory.
+// The index is expected to be in a file named <name>.json in the index directory.
 func LoadIndex(name string) (*Index, error) {
-	fp := indexFilepath(name)
+	index, err := readIndexFile(indexFilepath(name), name)
+	if index != nil || err != nil {
+		return index, err
+	}
+	// Index files used to be written to the current directory. Rather than quietly ignoring them
+	// (and e.g. building a duplicate), point out where they now belong.
+	legacy := name + ".json"
+	if same, _ := sameFile(legacy, indexFilepath(name)); !same {
+		if old, err := readIndexFile(legacy, name); err == nil && old != nil && old.Namespace != "" {
+			return nil, fmt.Errorf(
+				"index %q is in the current directory, it must be moved to %q",
+				name,
+				indexFilepath(name),
+			)
+		}
+	}
+	return nil, nil
+}
+
+// legacyIndexFiles returns the index files in the current directory, where they were written
+// before the index directory existed, unless the current directory is the index directory. Their
+// namespaces are still in use, but nothing else finds them until they're moved.
+func legacyIndexFiles() ([]string, error) {
+	if same, err := sameFile(".", indexDir()); err != nil || same {
+		return nil, err
+	}
+	var files []string
+	for _, suffix := range []string{".json", ".json.building"} {
+		matches, err := filepath.Glob("*" + suffix)
+		if err != nil {
+			return nil, fmt.Errorf("listing index files in the current directory: %w", err)
+		}
+		for _, fp := range matches {
+			// Only files naming their own index are index files; other JSON files are skipped.
+			name := strings.TrimSuffix(fp, suffix)
+			if index, err := readIndexFile(fp, name); err == nil && index != nil && index.Namespace != "" {
+				files = append(files, fp)
+			}
+		}
+	}
+	return files, nil
+}
+
+// sameFile reports whether paths a and b refer to the same location.
+func sameFile(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return absA == absB, nil
+}
+
+// LoadStagedIndex loads the staging file of an index which is being built, or whose build was
+// interrupted before it could be cleaned up. If there's no staging file, returns nil.
+func LoadStagedIndex(name string) (*Index, error) {
+	return readIndexFile(stagingFilepath(name), name)
+}
+
+// ListIndexes returns every index in the index directory, including those being built (see
+// LoadStagedIndex), sorted by name. Files which aren't index files are skipped.
+func ListIndexes() ([]*Index, error) {
+	return readIndexFiles(false)
+}
+
+// readIndexFiles is ListIndexes. If strict, index files which can't be parsed are an error rather
+// than skipped, for callers which must know every namespace in use, such as gc.
+func readIndexFiles(strict bool) ([]*Index, error) {
+	var indexes []*Index
+	for _, pattern := range []string{indexFilepath("*"), stagingFilepath("*")} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("listing index files: %w", err)
+		}
+		for _, fp := range matches {
+			data, err := os.ReadFile(fp)
+			if err != nil {
+				return nil, fmt.Errorf("reading index file %q: %w", fp, err)
+			}
+			// Other JSON files (e.g. namespaces persisted by the memory backend) may share the
+			// directory. Anything without a namespace isn't an index, or never got far enough
+			// to create one.
+			var index Index
+			if err := json.Unmarshal(data, &index); err != nil && strict {
+				return nil, fmt.Errorf("decoding index file %q: %w", fp, err)
+			} else if err != nil {
+				log.Printf("skipping %q, which isn't a valid index file: %v", fp, err)
+				continue
+			} else if index.Namespace == "" {
+				continue
+			}
+			indexes = append(indexes, &index)
+		}
+	}
+	slices.SortFunc(indexes, func(a, b *Index) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return indexes, nil
+}
+
+func readIndexFile(fp, name string) (*Index, error) {
 	f, err := os.Open(fp)
 	if os.IsNotExist(err) {
 		return nil, nil
@@ -60,13 +220,10 @@ func LoadIndex(name string) (*Index, error) {
 	return &index, nil
 }
 
-// Delete deletes the index both from turbopuffer and from local disk.
-func (idx *Index) Delete(ctx context.Context, tpuf *turbopuffer.Client) error {
-	ns := tpuf.Namespace(idx.Namespace)
-
-	var tpufError *turbopuffer.Error
-	if _, err := ns.DeleteAll(ctx, turbopuffer.NamespaceDeleteAllParams{}); err != nil {
-		if errors.As(err, &tpufError) && tpufError.StatusCode == http.StatusNotFound {
+// Delete deletes the index both from the backend and from local disk.
+func (idx *Index) Delete(ctx context.Context, backend Backend) error {
+	if err := backend.DeleteAll(ctx, idx.Namespace); err != nil {
+		if errors.Is(err, ErrNamespaceNotFound) {
 			return nil
 		}
 		return fmt.Errorf("deleting all rows in namespace %q: %w", idx.Namespace, err)
@@ -80,80 +237,393 @@ func (idx *Index) Delete(ctx context.Context, tpuf *turbopuffer.Client) error {
 	return nil
 }
 
-// NewIndex creates a new Index file with a given name, indexing a particular set.
-// If the index file already exists, returns an error.
-func NewIndex(ctx context.Context, tpuf *turbopuffer.Client, name string, set Set) (*Index, error) {
+// NewIndex creates a new Index file with a given name, indexing a particular set in the given mode.
+// The set is read from the local path source if given, otherwise it's downloaded from mtgjson.
+// Each card is embedded with the given embedder, unless it's NoEmbedder.
+//
+// The build is staged: the index is recorded as building in a staging file while it's uploaded,
+// and only renamed into place once the upload succeeds. Its progress is checkpointed as it goes,
+// see BuildCheckpoint. If anything fails (or ctx is cancelled) after progress was checkpointed, the
+// partially written namespace and the staging file are kept for ResumeIndex, otherwise they're
+// deleted. If the process dies, the staging file is left behind for ResumeIndex, or
+// DeleteStagedIndex.
+//
+// If the index file (or a staging file) already exists, returns an error.
+func NewIndex(
+	ctx context.Context,
+	backend Backend,
+	name string,
+	set Set,
+	source string,
+	embedderKind EmbedderKind,
+	mode IndexMode,
+) (_ *Index, err error) {
+	embedder, err := newEmbedder(embedderKind)
+	if err != nil {
+		return nil, err
+	}
+	if mode == PrintingMode && !set.HasPrintings() {
+		return nil, fmt.Errorf("printing indexes need a set with printings (allprintings or a set code), not %q", set)
+	}
+
+	if err := os.MkdirAll(indexDir(), 0o755); err != nil {
+		return nil, fmt.Errorf("creating index directory %q: %w", indexDir(), err)
+	}
 	fp := indexFilepath(name)
-	f, err := os.OpenFile(fp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
-	if os.IsExist(err) {
+	if _, err := os.Stat(fp); err == nil {
 		return nil, fmt.Errorf("index file %q already exists", fp)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("checking for index file %q: %w", fp, err)
+	}
+	// Creating the staging file exclusively also stops two builds of the same index racing.
+	staging := stagingFilepath(name)
+	f, err := os.OpenFile(staging, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf(
+			"index %q is already being built, or a previous build was interrupted (staging file %q exists)",
+			name,
+			staging,
+		)
 	} else if err != nil {
-		return nil, fmt.Errorf("creating index file %q: %w", fp, err)
+		return nil, fmt.Errorf("creating staging file %q: %w", staging, err)
 	}
-	defer f.Close()
+	f.Close()
 
-	start := time.Now()
-	log.Printf("downloading set %q from mtgjson...", set)
+	index := &Index{
+		Name:     name,
+		Set:      set,
+		Mode:     mode,
+		Embedder: embedderKind,
+		State:    IndexBuilding,
+	}
+	defer func() {
+		if err != nil {
+			err = index.failed(ctx, backend, err)
+		}
+	}()
+
+	nsName := turbopufferNamespace(name)
+	if err := ensureNamespaceDoesntExist(ctx, backend, nsName); err != nil {
+		return nil, fmt.Errorf("ensuring namespace %q doesn't exist: %w", nsName, err)
+	}
+	log.Printf("using namespace %q", nsName)
 
-	setObj, checksum, err := downloadSet(ctx, set)
+	// Record the namespace before writing to it, so it can always be found to be cleaned up.
+	index.Namespace = nsName
+	if err := index.writeFile(staging); err != nil {
+		return nil, err
+	}
+
+	if err := index.build(ctx, backend, source, embedder, nil); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// ResumeIndex resumes the interrupted build of the named index from its checkpoint, see
+// BuildCheckpoint, writing what's left into the same namespace. The set is read from source as for
+// NewIndex. The build must not still be running.
+func ResumeIndex(ctx context.Context, backend Backend, name string, source string) (_ *Index, err error) {
+	index, err := LoadStagedIndex(name)
 	if err != nil {
-		return nil, fmt.Errorf("downloading set %q: %w", set, err)
+		return nil, err
+	} else if index == nil {
+		return nil, fmt.Errorf("index %q has no interrupted build to resume", name)
 	}
-	log.Printf("downloaded set %q in %s", set, time.Since(start))
+	checkpoint, err := LoadCheckpoint(name)
+	if err != nil {
+		return nil, err
+	} else if checkpoint == nil {
+		return nil, fmt.Errorf("interrupted build of index %q has no checkpoint to resume from", name)
+	} else if checkpoint.Namespace != index.Namespace {
+		return nil, fmt.Errorf(
+			"checkpoint of index %q is for namespace %q, but the build is of namespace %q",
+			name,
+			checkpoint.Namespace,
+			index.Namespace,
+		)
+	}
+	embedder, err := newEmbedder(index.Embedder)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf(
+		"resuming build of index %q into namespace %q, %d cards were already written (checkpointed at %s)",
+		name,
+		index.Namespace,
+		checkpoint.Written(),
+		checkpoint.UpdatedAt.Format(time.RFC3339),
+	)
+	defer func() {
+		if err != nil {
+			err = index.failed(ctx, backend, err)
+		}
+	}()
+	if err := index.build(ctx, backend, source, embedder, checkpoint); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// build uploads the set of an index whose staging file has been written, and renames the staging
+// file into place once it's done. Progress is checkpointed as it goes. If checkpoint is non-nil,
+// the build resumes from it, skipping the cards it records as written.
+func (idx *Index) build(
+	ctx context.Context,
+	backend Backend,
+	source string,
+	embedder Embedder,
+	checkpoint *BuildCheckpoint,
+) error {
+	var previous map[string]IndexedCard
+	if checkpoint != nil {
+		previous = checkpoint.Cards
+	}
+	start := time.Now()
+	cards, meta, checksum, err := idx.upload(ctx, backend, source, previous, embedder, idx.saveCheckpoint)
+	if err != nil {
+		return err
+	}
+	log.Printf("uploaded set %q to namespace %q in %s", idx.Set, idx.Namespace, time.Since(start))
 	log.Printf("computed checksum: %s", checksum)
 
+	idx.CreatedAt = time.Now().UTC()
+	idx.Checksum = checksum
+	idx.Version = meta.Version
+	idx.Cards = cards
+	idx.State = IndexReady
+	staging, fp := stagingFilepath(idx.Name), indexFilepath(idx.Name)
+	if err := idx.writeFile(staging); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, fp); err != nil {
+		return fmt.Errorf("renaming staging file %q to %q: %w", staging, fp, err)
+	}
+	log.Printf("wrote index file %q", fp)
+
+	// The index is built, so there's nothing left to resume.
+	if err := idx.deleteCheckpoint(); err != nil {
+		log.Printf("warning: %v", err)
+	}
+	return nil
+}
+
+// failed handles a build of the index which failed with err. If the build checkpointed its
+// progress, its namespace and staging file are kept, so it can be resumed. Otherwise, they're
+// cleaned up.
+func (idx *Index) failed(ctx context.Context, backend Backend, err error) error {
+	checkpoint, checkpointErr := LoadCheckpoint(idx.Name)
+	if checkpointErr == nil && checkpoint != nil && checkpoint.Namespace == idx.Namespace {
+		log.Printf(
+			"build of index %q failed with %d cards written, which were checkpointed",
+			idx.Name,
+			checkpoint.Written(),
+		)
+		log.Printf("to resume the build, use: puffingmtg build %q again (with the same flags)", idx.Name)
+		log.Printf("to clean it up instead (including from turbopuffer), use: puffingmtg delete %q", idx.Name)
+		return err
+	}
+	if cleanupErr := idx.cleanupStaged(ctx, backend); cleanupErr != nil {
+		return errors.Join(err, fmt.Errorf("cleaning up failed build: %w", cleanupErr))
+	}
+	return err
+}
+
+// upload streams the index's set into its namespace, as it's read from source (or downloaded, if
+// source is empty) and decoded, so even the largest sets are uploaded in bounded memory. previous
+// and embedder are as for upsertSet, and checkpoint, if non-nil, is as for uploadHooks. Returns
+// what the namespace now holds, along with the metadata and checksum of the set.
+func (idx *Index) upload(
+	ctx context.Context,
+	backend Backend,
+	source string,
+	previous map[string]IndexedCard,
+	embedder Embedder,
+	checkpoint func(batch int64, cards map[string]IndexedCard) error,
+) (map[string]IndexedCard, Meta, string, error) {
+	if source != "" {
+		log.Printf("reading set %q from %q...", idx.Set, source)
+	} else {
+		log.Printf("downloading set %q from mtgjson...", idx.Set)
+	}
+	r, err := openSet(ctx, idx.Set, source)
+	if err != nil {
+		return nil, Meta{}, "", fmt.Errorf("loading set %q: %w", idx.Set, err)
+	}
+	defer r.Close()
+
 	var (
-		nsName = turbopufferNamespace(name, checksum)
-		ns     = tpuf.Namespace(nsName)
+		cards    map[string]IndexedCard
+		meta     Meta
+		checksum string
+		hooks    = &uploadHooks{progress: r.Progress, checkpoint: checkpoint}
 	)
-	if err := ensureNamespaceDoesntExist(ctx, ns); err != nil {
-		return nil, fmt.Errorf("ensuring namespace %q doesn't exist: %w", nsName, err)
+	if idx.Mode == PrintingMode {
+		printings := func(yield func(string, []printingFace) error) error {
+			var err error
+			meta, checksum, err = streamPrintings(r, idx.Set, printingEntries(yield))
+			return err
+		}
+		cards, err = upsertPrintings(ctx, backend, idx.Namespace, printings, previous, embedder, hooks)
+	} else {
+		set := func(yield func(string, []AtomicCard) error) error {
+			var err error
+			meta, checksum, err = streamCards(r, idx.Set, yield)
+			return err
+		}
+		cards, err = upsertSet(ctx, backend, idx.Namespace, set, previous, embedder, hooks)
+	}
+	if err != nil {
+		return nil, Meta{}, "", fmt.Errorf("uploading set %q to backend: %w", idx.Set, err)
 	}
-	log.Printf("using turbopuffer namespace %q", nsName)
+	return cards, meta, checksum, nil
+}
+
+// cleanupStaged deletes the namespace of an index which failed to build (if it got as far as
+// creating one), and its staging file and checkpoint.
+func (idx *Index) cleanupStaged(ctx context.Context, backend Backend) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
 
-	if err := upsertSet(ctx, ns, setObj); err != nil {
-		return nil, fmt.Errorf("uploading set to turbopuffer: %w", err)
+	if idx.Namespace != "" {
+		log.Printf("deleting partially built namespace %q...", idx.Namespace)
+		if err := backend.DeleteAll(ctx, idx.Namespace); err != nil && !errors.Is(err, ErrNamespaceNotFound) {
+			return fmt.Errorf("deleting namespace %q: %w", idx.Namespace, err)
+		}
 	}
-	log.Printf("uploaded set to turbopuffer namespace %q", nsName)
+	if err := idx.deleteCheckpoint(); err != nil {
+		return err
+	}
+	staging := stagingFilepath(idx.Name)
+	if err := os.Remove(staging); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("deleting staging file %q: %w", staging, err)
+	}
+	return nil
+}
 
-	index := &Index{
-		Name:      name,
-		Namespace: nsName,
-		CreatedAt: time.Now().UTC(),
-		Checksum:  checksum,
-		Set:       set,
+// DeleteStagedIndex cleans up after a build of the named index which was interrupted without
+// cleaning up after itself, e.g. because the process was killed. Returns whether there was
+// anything to clean up.
+func DeleteStagedIndex(ctx context.Context, backend Backend, name string) (bool, error) {
+	staged, err := LoadStagedIndex(name)
+	if err != nil {
+		// An empty or truncated staging file means the build died before recording a namespace.
+		var syntaxErr *json.SyntaxError
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.As(err, &syntaxErr) {
+			return false, err
+		}
+		staged = &Index{Name: name}
+	} else if staged == nil {
+		return false, nil
 	}
-	if err := json.NewEncoder(f).Encode(index); err != nil {
-		return nil, fmt.Errorf("writing index file %q: %w", fp, err)
+	if err := staged.cleanupStaged(ctx, backend); err != nil {
+		return false, err
 	}
-	log.Printf("wrote index file %q", fp)
+	return true, nil
+}
 
-	return index, nil
+// Refresh reloads the index's set and, if it changed since the index was built (or last refreshed),
+// updates the index in place: changed cards are upserted, removed cards are deleted, and
+// everything else is left untouched. The set is read from source if given, otherwise it's
+// downloaded from mtgjson. Returns whether anything changed.
+func (idx *Index) Refresh(ctx context.Context, backend Backend, source string) (bool, error) {
+	if idx.Cards == nil {
+		return false, fmt.Errorf(
+			"index %q predates in-place refreshes, it must be deleted and built again",
+			idx.Name,
+		)
+	}
+
+	embedder, err := newEmbedder(idx.Embedder)
+	if err != nil {
+		return false, err
+	}
+
+	// The set is streamed, so whether it changed is only known once it's been read in full. If it
+	// didn't, every card's checksum matched, and nothing was written.
+	cards, meta, checksum, err := idx.upload(ctx, backend, source, idx.Cards, embedder, nil)
+	if err != nil {
+		return false, err
+	}
+	if checksum == idx.Checksum {
+		log.Printf("set %q is unchanged (checksum %s)", idx.Set, checksum)
+		return false, nil
+	}
+	log.Printf("set %q changed (checksum %s -> %s)", idx.Set, idx.Checksum, checksum)
+
+	now := time.Now().UTC()
+	idx.Checksum = checksum
+	idx.Version = meta.Version
+	idx.UpdatedAt = &now
+	idx.Cards = cards
+	if err := idx.save(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// save overwrites the index file with the current state of the index. The file is replaced
+// atomically, so readers never see a partially written index.
+func (idx *Index) save() error {
+	return idx.writeFile(indexFilepath(idx.Name))
+}
+
+// writeFile atomically replaces the file at fp with the index, via a temporary file.
+func (idx *Index) writeFile(fp string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("encoding index %q: %w", idx.Name, err)
+	}
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing index file %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, fp); err != nil {
+		return fmt.Errorf("renaming index file %q: %w", tmp, err)
+	}
+	return nil
 }
 
 func indexFilepath(name string) string {
-	return fmt.Sprintf("%s.json", name)
+	return filepath.Join(indexDir(), name+".json")
+}
+
+// stagingFilepath is where the metadata of an index is kept while it's being built. It's renamed
+// to indexFilepath once the build succeeds.
+func stagingFilepath(name string) string {
+	return filepath.Join(indexDir(), name+".json.building")
+}
+
+// openSet opens a set for reading, either from the local path source or, if source is empty, by
+// downloading it from mtgjson.
+func openSet(ctx context.Context, set Set, source string) (*setReader, error) {
+	if source != "" {
+		return openLocalSet(set, source)
+	}
+	return downloadSet(ctx, set)
 }
 
-func downloadSet(ctx context.Context, set Set) (*AtomicSet, string, error) {
+func downloadSet(ctx context.Context, set Set) (*setReader, error) {
 	url, err := set.DownloadURL()
 	if err != nil {
-		return nil, "", fmt.Errorf("getting download URL for set %q: %w", set, err)
+		return nil, fmt.Errorf("getting download URL for set %q: %w", set, err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, "", fmt.Errorf("creating request for %q: %w", url, err)
+		return nil, fmt.Errorf("creating request for %q: %w", url, err)
 	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, "", fmt.Errorf("downloading set from %q: %w", url, err)
+		return nil, fmt.Errorf("downloading set from %q: %w", url, err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf(
+		resp.Body.Close()
+		return nil, fmt.Errorf(
 			"downloading set from %q: unexpected status code %d (status text: %s)",
 			url,
 			resp.StatusCode,
@@ -161,114 +631,446 @@ func downloadSet(ctx context.Context, set Set) (*AtomicSet, string, error) {
 		)
 	}
 
-	// As we're reading the response body for JSON deserialization, we'll compute a rolling
-	// checksum of the underlying data. We'll store this in the index object.
-	var (
-		hasher = sha256.New()
-		tee    = io.TeeReader(resp.Body, hasher)
-	)
-
-	var atomicSet AtomicSet
-	if err := json.NewDecoder(tee).Decode(&atomicSet); err != nil {
-		return nil, "", fmt.Errorf("decoding set from %q: %w", url, err)
-	}
-
-	checksum := hex.EncodeToString(hasher.Sum(nil))
-
-	return &atomicSet, checksum, nil
+	file := &countingReader{r: resp.Body}
+	return &setReader{Reader: file, Closer: resp.Body, file: file, size: resp.ContentLength}, nil
 }
 
-func turbopufferNamespace(name, checksum string) string {
+// namespacePrefix is the prefix of every namespace created for an index.
+const namespacePrefix = "mtg_"
+
+// turbopufferNamespace returns a new namespace name for an index. Sets are streamed into their
+// namespace as they're read, so their checksum isn't known yet, and a random suffix keeps names
+// unique instead.
+func turbopufferNamespace(name string) string {
 	now := time.Now().UTC().Format("20060102-150405")
-	return fmt.Sprintf("mtg_%s_%s_%s", name, now, checksum[:8])
+	return fmt.Sprintf("%s%s_%s_%08x", namespacePrefix, name, now, rand.Uint32())
 }
 
-func ensureNamespaceDoesntExist(ctx context.Context, ns turbopuffer.Namespace) error {
-	var tpufError *turbopuffer.Error
-	meta, err := ns.Metadata(ctx, turbopuffer.NamespaceMetadataParams{})
+func ensureNamespaceDoesntExist(ctx context.Context, backend Backend, namespace string) error {
+	meta, err := backend.Metadata(ctx, namespace)
 	if err != nil {
-		if errors.As(err, &tpufError) && tpufError.StatusCode == http.StatusNotFound {
+		if errors.Is(err, ErrNamespaceNotFound) {
 			return nil
 		}
 		return fmt.Errorf("checking namespace metadata: %w", err)
 	}
-	return fmt.Errorf("namespace %q already exists (created at %s)", ns.ID(), meta.CreatedAt)
+	return fmt.Errorf("namespace %q already exists (created at %s)", namespace, meta.CreatedAt)
 }
 
-func upsertSet(ctx context.Context, ns turbopuffer.Namespace, set *AtomicSet) error {
-	const (
-		targetBatchSize  = 128 << 20 // 128MB
-		estimatedRowSize = 1 << 10   // 1KB
+// upsertSet writes the cards of set to a namespace as they're streamed. If previous is non-nil, it
+// describes what the namespace already holds (as returned by an earlier call), and only the
+// differences are written: cards whose checksum is unchanged are skipped, and rows which are no
+// longer part of the set (e.g. removed cards or faces) are deleted. If embedder is non-nil, every
+// row written is embedded. hooks, if non-nil, observe the upload. Returns a description of what the
+// namespace now holds.
+func upsertSet(
+	ctx context.Context,
+	backend Backend,
+	namespace string,
+	set entryStream[AtomicCard],
+	previous map[string]IndexedCard,
+	embedder Embedder,
+	hooks *uploadHooks,
+) (map[string]IndexedCard, error) {
+	return upsertEntries(
+		ctx,
+		backend,
+		namespace,
+		set,
+		previous,
+		embedder,
+		hooks,
+		turbopufferSchema(),
+		func(name string, card AtomicCard) Row { return buildRow(rowID(name, card), name, card) },
 	)
-	batch := make([]turbopuffer.RowParam, 0, targetBatchSize/estimatedRowSize)
-	flush := func() error {
-		if len(batch) == 0 {
-			return nil
-		}
-		if _, err := ns.Write(ctx, turbopuffer.NamespaceWriteParams{
-			UpsertRows: batch,
-			Schema:     turbopufferSchema(),
-		}); err != nil {
-			return fmt.Errorf("writing batch of %d rows: %w", len(batch), err)
-		}
-		batch = batch[:0]
-		return nil
+}
+
+// upsertEntries implements upsertSet for any kind of entry: entries streams the key of each entry
+// (e.g. a card name) along with its faces, and buildRow builds the row of a face of an entry.
+// Entries are written, skipped and deleted as a whole.
+func upsertEntries[T any](
+	ctx context.Context,
+	backend Backend,
+	namespace string,
+	entries entryStream[T],
+	previous map[string]IndexedCard,
+	embedder Embedder,
+	hooks *uploadHooks,
+	schema Schema,
+	buildRow func(key string, face T) Row,
+) (map[string]IndexedCard, error) {
+	if hooks == nil {
+		hooks = &uploadHooks{}
+	}
+	up, err := newUploader(ctx, backend, namespace, schema, embedder, hooks.progress)
+	if err != nil {
+		return nil, err
 	}
 	var (
+		cards      = make(map[string]IndexedCard, len(previous))
 		numCards   int
-		numFlushes int
+		numSkipped int
+
+		// pending holds the entries which may not have been written yet, with the number of the
+		// last batch holding any of their rows or deletes.
+		pending        = make(map[string]int64)
+		lastCheckpoint = time.Now()
 	)
-	for _, cards := range set.Data {
-		for _, card := range cards {
-			batch = append(batch, buildRow(card))
+	checkpoint := func() error {
+		if hooks.checkpoint == nil {
+			return nil
+		}
+		lastCheckpoint = time.Now()
+		confirmed := up.lastConfirmed()
+		if confirmed == 0 && previous == nil {
+			return nil
+		}
+		return hooks.checkpoint(confirmed, checkpointCards(previous, cards, pending, confirmed))
+	}
+	abort := func(err error) error {
+		up.abort(err)
+		if checkpointErr := checkpoint(); checkpointErr != nil {
+			return errors.Join(err, fmt.Errorf("checkpointing: %w", checkpointErr))
+		}
+		return err
+	}
+
+	err = entries(func(name string, faces []T) error {
+		checksum, err := cardChecksum(faces)
+		if err != nil {
+			return fmt.Errorf("computing checksum of card %q: %w", name, err)
+		}
+		prev, existed := previous[name]
+		if existed && prev.Checksum == checksum {
+			cards[name] = prev
+			numSkipped += 1
+			return nil
+		}
+
+		card := IndexedCard{Checksum: checksum, IDs: make([]string, 0, len(faces))}
+		rows := make([]Row, 0, len(faces))
+		for _, face := range faces {
+			row := buildRow(name, face)
+			card.IDs = append(card.IDs, row["id"].(string))
+			rows = append(rows, row)
+		}
+		// Record the card before any of it is written, so a checkpoint never misses its rows.
+		cards[name] = card
+		pending[name] = math.MaxInt64
+		for _, row := range rows {
+			if err := up.upsert(row); err != nil {
+				return err
+			}
 			numCards += 1
-			if len(batch)*estimatedRowSize >= targetBatchSize {
-				if err := flush(); err != nil {
+		}
+		for _, id := range prev.IDs {
+			if !slices.Contains(card.IDs, id) {
+				if err := up.delete(id); err != nil {
 					return err
 				}
-				numFlushes += 1
 			}
 		}
+		pending[name] = up.building()
+
+		if time.Since(lastCheckpoint) >= checkpointInterval {
+			return checkpoint()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, abort(err)
 	}
-	if err := flush(); err != nil {
-		return err
+
+	// Batches are written concurrently, in no particular order, so a row of a removed card mustn't
+	// be deleted if another card now has it (e.g. because the card was renamed). Otherwise the
+	// delete might land after the upsert.
+	live := make(map[string]bool)
+	for _, card := range cards {
+		for _, id := range card.IDs {
+			live[id] = true
+		}
+	}
+	for name, prev := range previous {
+		if _, ok := cards[name]; ok {
+			continue
+		}
+		for _, id := range prev.IDs {
+			if live[id] {
+				continue
+			}
+			if err := up.delete(id); err != nil {
+				return nil, abort(err)
+			}
+		}
+	}
+	if err := up.wait(); err != nil {
+		return nil, abort(err)
 	}
-	numFlushes += 1
 
-	log.Printf("uploaded %d cards (%d flushes)", numCards, numFlushes)
+	log.Printf(
+		"uploaded %d cards, deleted %d rows, skipped %d unchanged (%d batches in %s)",
+		numCards,
+		up.deleted.Load(),
+		numSkipped,
+		up.batches.Load(),
+		time.Since(up.start).Round(time.Millisecond),
+	)
 
-	return nil
+	return cards, nil
+}
+
+// parseStat parses a power or toughness as a number, for comparisons. Returns nil for stats which
+// aren't plain numbers, e.g. "*" or "1+*".
+func parseStat(stat *string) *float64 {
+	if stat == nil {
+		return nil
+	}
+	value, err := strconv.ParseFloat(*stat, 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+// rowIDNamespace is the UUIDv5 namespace row IDs are derived in. It must never change, otherwise
+// every row ID changes with it.
+var rowIDNamespace = uuid.MustParse("2818273b-2899-48fd-a7c0-027f054810ce")
+
+// rowID returns the ID of the row for a card face. IDs are derived from the identity of the card,
+// so they're stable across builds and mtgjson releases: a face always gets the same ID, and
+// writing it again overwrites the existing row rather than adding a duplicate. Faces are
+// identified by the name of their card (the key of its entry, e.g. "Fire // Ice") and their side,
+// along with their Scryfall oracle ID if they have one. The card's name is needed as the same
+// face can belong to several cards, e.g. the meld result Brisela, Voice of Nightmares, is a face
+// of both Bruna and Gisela.
+func rowID(name string, card AtomicCard) string {
+	var side string
+	if card.Side != nil {
+		side = *card.Side
+	}
+	key := fmt.Sprintf("name:%s:%s", name, side)
+	if card.Identifiers.ScryfallOracleId != nil && *card.Identifiers.ScryfallOracleId != "" {
+		key = fmt.Sprintf("oracle:%s:%s:%s", *card.Identifiers.ScryfallOracleId, name, side)
+	}
+	return uuid.NewSHA1(rowIDNamespace, []byte(key)).String()
+}
+
+// cardID returns the ID shared by all faces of a card, identifying the card as a whole. It's
+// derived from the name of the card (the key of its entry), as the faces of some cards (e.g. meld
+// results and reversible cards) have oracle IDs of their own.
+func cardID(name string) string {
+	return uuid.NewSHA1(rowIDNamespace, []byte("card:name:"+name)).String()
+}
+
+// cardChecksum returns the hex SHA256 of a card's faces, as JSON.
+func cardChecksum[T any](faces []T) (string, error) {
+	data, err := json.Marshal(faces)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
 
-func buildRow(card AtomicCard) turbopuffer.RowParam {
-	return turbopuffer.RowParam{
-		"id":                  uuid.NewString(),
-		"types":               card.Types,
-		"power":               card.Power,
-		"toughness":           card.Toughness,
-		"name":                card.Name,
-		"edhrec_rank":         card.EdhrecRank,
-		"edhrec_saltiness":    card.EdhrecSaltiness,
-		"colors":              card.Colors,
-		"converted_mana_cost": card.ManaValue,
-		"mana_cost":           card.ManaCost,
-		"rulings":             card.Rulings.AsTexts(),
-		"starting_loyalty":    card.Loyalty,
-		"text":                card.Text,
+// buildRow builds the row for a face of the card with the given name.
+func buildRow(id, name string, card AtomicCard) Row {
+	row := Row{
+		"id":                               id,
+		"card_id":                          cardID(name),
+		"side":                             card.Side,
+		"face_name":                        card.FaceName,
+		"layout":                           card.Layout,
+		"ascii_name":                       card.AsciiName,
+		"type":                             card.Type,
+		"types":                            card.Types,
+		"subtypes":                         card.Subtypes,
+		"supertypes":                       card.Supertypes,
+		"keywords":                         card.Keywords,
+		"power":                            card.Power,
+		"toughness":                        card.Toughness,
+		"power_value":                      parseStat(card.Power),
+		"toughness_value":                  parseStat(card.Toughness),
+		"defense":                          card.Defense,
+		"hand":                             card.Hand,
+		"life":                             card.Life,
+		"name":                             card.Name,
+		"edhrec_rank":                      card.EdhrecRank,
+		"edhrec_saltiness":                 card.EdhrecSaltiness,
+		"colors":                           card.Colors,
+		"color_identity":                   card.ColorIdentity,
+		"color_indicator":                  card.ColorIndicator,
+		"converted_mana_cost":              card.ManaValue,
+		"face_mana_value":                  card.FaceManaValue,
+		"mana_cost":                        card.ManaCost,
+		"rulings":                          card.Rulings.AsTexts(),
+		"starting_loyalty":                 card.Loyalty,
+		"text":                             card.Text,
+		"legal_formats":                    card.Legalities.WithStatus("Legal"),
+		"restricted_formats":               card.Legalities.WithStatus("Restricted"),
+		"banned_formats":                   card.Legalities.WithStatus("Banned"),
+		"leadership_skills":                card.LeadershipSkills.Formats(),
+		"printings":                        card.Printings,
+		"first_printing":                   card.FirstPrinting,
+		"subsets":                          card.Subsets,
+		"attraction_lights":                card.AttractionLights,
+		"is_reserved":                      card.IsReserved != nil && *card.IsReserved,
+		"is_game_changer":                  card.IsGameChanger != nil && *card.IsGameChanger,
+		"is_funny":                         card.IsFunny != nil && *card.IsFunny,
+		"has_alternative_deck_limit":       card.HasAlternativeDeckLimit != nil && *card.HasAlternativeDeckLimit,
+		"scryfall_oracle_id":               card.Identifiers.ScryfallOracleId,
+		"reverse_related":                  card.RelatedCards.ReverseRelated,
+		"spellbook":                        card.RelatedCards.Spellbook,
+		"purchase_url_card_kingdom":        card.PurchaseUrls.CardKingdom,
+		"purchase_url_card_kingdom_etched": card.PurchaseUrls.CardKingdomEtched,
+		"purchase_url_card_kingdom_foil":   card.PurchaseUrls.CardKingdomFoil,
+		"purchase_url_cardmarket":          card.PurchaseUrls.Cardmarket,
+		"purchase_url_tcgplayer":           card.PurchaseUrls.Tcgplayer,
+		"purchase_url_tcgplayer_etched":    card.PurchaseUrls.TcgplayerEtched,
 	}
+	addForeignData(row, card)
+	return row
 }
 
-func turbopufferSchema() map[string]turbopuffer.AttributeSchemaConfigParam {
-	return map[string]turbopuffer.AttributeSchemaConfigParam{
+func turbopufferSchema() Schema {
+	schema := Schema{
 		"id": {
 			Type: turbopuffer.Opt(turbopuffer.AttributeType("uuid")),
 		},
+		"card_id": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("uuid")),
+		},
+		"side": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
+		"face_name": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
+		"layout": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
 		"types": {
 			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
 		},
+		"ascii_name": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
+		"type": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+			FullTextSearch: &turbopuffer.FullTextSearchConfigParam{
+				Stemming:        turbopuffer.Bool(true),
+				RemoveStopwords: turbopuffer.Bool(false),
+			},
+			Filterable: turbopuffer.Bool(true),
+		},
+		"subtypes": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"supertypes": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"keywords": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+			FullTextSearch: &turbopuffer.FullTextSearchConfigParam{
+				RemoveStopwords: turbopuffer.Bool(false),
+			},
+			Filterable: turbopuffer.Bool(true),
+		},
+		"defense": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
+		"hand": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
+		"life": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
+		"color_identity": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"color_indicator": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"face_mana_value": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("float")),
+		},
+		"legal_formats": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"restricted_formats": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"banned_formats": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"leadership_skills": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"printings": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"first_printing": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
+		"subsets": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"attraction_lights": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]int")),
+		},
+		"is_reserved": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("bool")),
+		},
+		"is_game_changer": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("bool")),
+		},
+		"is_funny": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("bool")),
+		},
+		"has_alternative_deck_limit": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("bool")),
+		},
+		"scryfall_oracle_id": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		},
+		"reverse_related": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"spellbook": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+		},
+		"purchase_url_card_kingdom": {
+			Type:       turbopuffer.Opt(turbopuffer.AttributeType("string")),
+			Filterable: turbopuffer.Bool(false),
+		},
+		"purchase_url_card_kingdom_etched": {
+			Type:       turbopuffer.Opt(turbopuffer.AttributeType("string")),
+			Filterable: turbopuffer.Bool(false),
+		},
+		"purchase_url_card_kingdom_foil": {
+			Type:       turbopuffer.Opt(turbopuffer.AttributeType("string")),
+			Filterable: turbopuffer.Bool(false),
+		},
+		"purchase_url_cardmarket": {
+			Type:       turbopuffer.Opt(turbopuffer.AttributeType("string")),
+			Filterable: turbopuffer.Bool(false),
+		},
+		"purchase_url_tcgplayer": {
+			Type:       turbopuffer.Opt(turbopuffer.AttributeType("string")),
+			Filterable: turbopuffer.Bool(false),
+		},
+		"purchase_url_tcgplayer_etched": {
+			Type:       turbopuffer.Opt(turbopuffer.AttributeType("string")),
+			Filterable: turbopuffer.Bool(false),
+		},
 		"power": {
 			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
 		},
+		"power_value": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("float")),
+		},
+		"toughness_value": {
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("float")),
+		},
 		"toughness": {
 			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
 		},
@@ -289,7 +1091,8 @@ func turbopufferSchema() map[string]turbopuffer.AttributeSchemaConfigParam {
 			Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
 		},
 		"converted_mana_cost": {
-			Type: turbopuffer.Opt(turbopuffer.AttributeType("uint")),
+			// Not uint: some Un-cards have half mana values, e.g. 0.5.
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("float")),
 		},
 		"mana_cost": {
 			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
@@ -311,28 +1114,440 @@ func turbopufferSchema() map[string]turbopuffer.AttributeSchemaConfigParam {
 			},
 		},
 	}
+	addForeignSchema(schema)
+	return schema
+}
+
+// SearchResult is a card matched by a search. Cards with multiple faces (split, transform, modal
+// double-faced, adventure, flip, ...) are a single result, carrying all of their faces. In printing
+// indexes, each printing of a card is a separate result, unless printings are collapsed.
+type SearchResult struct {
+	// CardID is shared by every face of the card, see cardID.
+	CardID string `json:"card_id"`
+
+	// PrintingID is shared by every face of the printing, see printingID. Only set by printing
+	// indexes, where each result is a printing of a card.
+	PrintingID string `json:"printing_id,omitempty"`
+
+	// Name is the full name of the card, e.g. "Fire // Ice".
+	Name string `json:"name"`
+
+	// Layout is the mtgjson layout of the card, e.g. "split" or "transform".
+	Layout string `json:"layout"`
+
+	// Score is the score of the best matching face: its BM25 score, cosine similarity to the query,
+	// or fused score, depending on the search mode. Higher is better.
+	Score float64 `json:"score"`
+
+	// Faces are the card's faces, ordered by side. Single-faced cards have a single face.
+	Faces []Row `json:"faces"`
+}
+
+// searchAttributes are the attributes included for each face in search results.
+var searchAttributes = []string{"card_id", "name", "face_name", "side", "layout", "mana_cost", "text"}
+
+// maxCardFaces bounds how many faces a single card can have (e.g. "Who // What // When // Where //
+// Why" has five).
+const maxCardFaces = 8
+
+// SearchMode is an enumeration of the ways free text queries are matched against cards.
+type SearchMode string
+
+// List of supported search modes.
+var (
+	// KeywordSearch ranks cards by the BM25 score of their name and text.
+	KeywordSearch SearchMode = "bm25"
+
+	// VectorSearch ranks cards by the similarity of their embedding to the query's.
+	VectorSearch SearchMode = "vector"
+
+	// HybridSearch fuses the rankings of KeywordSearch and VectorSearch with reciprocal rank
+	// fusion, so cards ranked well by either show up.
+	HybridSearch SearchMode = "hybrid"
+)
+
+func (m SearchMode) Valid() bool {
+	switch m {
+	case KeywordSearch, VectorSearch, HybridSearch:
+		return true
+	default:
+		return false
+	}
 }
 
-// Search performs a search query against the index, returning up to topk results.
-func (idx *Index) Search(
+// rrfK is the constant of reciprocal rank fusion, damping the influence of the top ranks. 60 is
+// the value from the original paper, and what most implementations use.
+const rrfK = 60
+
+// SearchRequest describes a search of an index.
+type SearchRequest struct {
+	// Query is free text, ranked against the cards according to Mode. If empty, every card
+	// matching the filters is a result.
+	Query string
+
+	// Mode is how Query is matched. If empty, hybrid search is used for indexes with vectors,
+	// and keyword search otherwise.
+	Mode SearchMode
+
+	// Lang is the code of the language Query is in, e.g. "de", see languages. Query is then
+	// matched against the names, types and text of cards in that language, but results are still
+	// the English cards. Only supported by keyword search. If empty, Query is in English.
+	Lang string
+
+	// Filters restricts which cards can be results.
+	Filters SearchFilters
+
+	// TopK is the maximum number of cards to return.
+	TopK int
+
+	// Collapse makes printing indexes return a single result per card, its best matching
+	// printing, rather than a result per printing. Has no effect on card indexes.
+	Collapse bool
+
+	// Attributes are included for each face of the results, on top of searchAttributes.
+	Attributes []string
+
+	// Profile ranks keyword matches of Query. If nil, the DefaultProfile is used.
+	Profile *RankingProfile
+}
+
+// SearchMode returns the search mode used for a request with the given mode and language,
+// resolving the default. Returns an error if the index doesn't support the mode, or the mode
+// doesn't support the language.
+func (idx *Index) SearchMode(mode SearchMode, lang string) (SearchMode, error) {
+	foreign, err := lookupLanguage(lang)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case mode == "" && idx.hasVectors() && foreign == nil:
+		return HybridSearch, nil
+	case mode == "":
+		return KeywordSearch, nil
+	case !mode.Valid():
+		return "", fmt.Errorf("invalid search mode %q, must be one of bm25, vector, hybrid", mode)
+	case mode != KeywordSearch && !idx.hasVectors():
+		return "", fmt.Errorf("index %q has no vectors, so only bm25 search is supported", idx.Name)
+	case mode != KeywordSearch && foreign != nil:
+		// Cards are only embedded in English.
+		return "", fmt.Errorf("only bm25 search supports searching in %s", foreign.Name)
+	default:
+		return mode, nil
+	}
+}
+
+func (idx *Index) hasVectors() bool {
+	return idx.Embedder != "" && idx.Embedder != NoEmbedder
+}
+
+// Search performs a search query against the index, returning up to req.TopK cards.
+func (idx *Index) Search(ctx context.Context, backend Backend, req SearchRequest) ([]SearchResult, error) {
+	if req.Query == "" && req.Filters.Empty() {
+		return nil, errors.New("search must have a query or filters")
+	}
+	mode, err := idx.SearchMode(req.Mode, req.Lang)
+	if err != nil {
+		return nil, err
+	}
+	lang, err := lookupLanguage(req.Lang)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := req.Profile
+	if profile == nil {
+		defaultProfile, err := LookupProfile(DefaultProfile)
+		if err != nil {
+			return nil, err
+		}
+		profile = &defaultProfile
+	}
+	boost := profile.PopularityBoost > 0 && req.Query != ""
+
+	// Each face of a card is a separate row, and several faces of one card may match. Ask for
+	// more rows than cards so we'll usually still end up with TopK cards.
+	collapse := idx.Mode == PrintingMode && req.Collapse
+	extra := req.Attributes
+	if boost {
+		extra = append(slices.Clip(extra), "edhrec_rank")
+	}
+	attrs := idx.searchAttributes(lang, extra)
+	base := QueryRequest{
+		Filters:           req.Filters.Compile(),
+		TopK:              req.TopK * 2,
+		IncludeAttributes: attrs,
+	}
+	if collapse {
+		base.TopK = req.TopK * collapseOversample
+	}
+	if boost {
+		base.TopK = max(base.TopK, req.TopK*popularityOversample)
+	}
+
+	var rows []Row
+	switch {
+	case req.Query == "":
+		rows, err = idx.query(ctx, backend, base)
+	case mode == KeywordSearch:
+		rows, err = idx.query(ctx, backend, keywordQuery(base, req.Query, lang, *profile))
+	case mode == VectorSearch:
+		var query QueryRequest
+		if query, err = idx.vectorQuery(ctx, base, req.Query); err != nil {
+			return nil, err
+		}
+		rows, err = idx.query(ctx, backend, query)
+		for _, row := range rows {
+			// Report similarities rather than distances, so higher scores are better in every mode.
+			dist, _ := row["$dist"].(float64)
+			row["$dist"] = 1 - dist
+		}
+	default:
+		rows, err = idx.hybridQuery(ctx, backend, base, req.Query, *profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if boost {
+		boostPopular(rows, profile.PopularityBoost)
+	}
+
+	groupBy := idx.groupBy()
+	if collapse {
+		rows, groupBy = bestPrintings(rows), "card_id"
+	}
+	results := groupFaces(rows, req.TopK, groupBy)
+	if err := idx.fetchFaces(ctx, backend, results, attrs); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (idx *Index) query(ctx context.Context, backend Backend, query QueryRequest) ([]Row, error) {
+	rows, err := backend.Query(ctx, idx.Namespace, query)
+	if err != nil {
+		return nil, fmt.Errorf("querying namespace %q: %w", idx.Namespace, err)
+	}
+	return rows, nil
+}
+
+// keywordQuery returns base, ranked by the BM25 score of text against the attributes weighed by
+// profile. If lang is non-nil, text is matched against the name, type and text of cards in that
+// language instead: foreign names and types are what players know cards by, as the English name is
+// often unfamiliar to them.
+func keywordQuery(base QueryRequest, text string, lang *Language, profile RankingProfile) QueryRequest {
+	base.Text = text
+	if lang != nil {
+		base.Text = lang.segment(text)
+	}
+	base.Fields = profile.fields(lang)
+	return base
+}
+
+// vectorQuery returns base, ranked by the distance of cards to the embedding of text.
+func (idx *Index) vectorQuery(ctx context.Context, base QueryRequest, text string) (QueryRequest, error) {
+	embedder, err := newEmbedder(idx.Embedder)
+	if err != nil {
+		return QueryRequest{}, err
+	}
+	vectors, err := embedder.Embed(ctx, []string{text})
+	if err != nil {
+		return QueryRequest{}, fmt.Errorf("embedding query: %w", err)
+	}
+	base.Vector = vectors[0]
+	return base, nil
+}
+
+// hybridQuery runs keyword and vector queries for text concurrently, and fuses their rankings with
+// reciprocal rank fusion. The "$dist" of each row is its fused score.
+func (idx *Index) hybridQuery(
 	ctx context.Context,
-	tpuf *turbopuffer.Client,
-	query string,
-	topk int,
-) ([]turbopuffer.Row, error) {
-	ns := tpuf.Namespace(idx.Namespace)
-	resp, err := ns.Query(ctx, turbopuffer.NamespaceQueryParams{
-		RankBy: turbopuffer.NewRankByTextSum([]turbopuffer.RankByText{
-			turbopuffer.NewRankByTextProduct(2.0, turbopuffer.NewRankByTextBM25("name", query)),
-			turbopuffer.NewRankByTextProduct(1.0, turbopuffer.NewRankByTextBM25("text", query)),
-		}),
-		TopK: turbopuffer.Int(int64(topk)),
-		IncludeAttributes: turbopuffer.IncludeAttributesParam{
-			StringArray: []string{"name", "mana_cost", "text"},
-		},
+	backend Backend,
+	base QueryRequest,
+	text string,
+	profile RankingProfile,
+) ([]Row, error) {
+	vector, err := idx.vectorQuery(ctx, base, text)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg                      sync.WaitGroup
+		keywordRows, vectorRows []Row
+		keywordErr, vectorErr   error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		keywordRows, keywordErr = idx.query(ctx, backend, keywordQuery(base, text, nil, profile))
+	}()
+	go func() {
+		defer wg.Done()
+		vectorRows, vectorErr = idx.query(ctx, backend, vector)
+	}()
+	wg.Wait()
+	if err := errors.Join(keywordErr, vectorErr); err != nil {
+		return nil, err
+	}
+
+	rows := reciprocalRankFusion(keywordRows, vectorRows)
+	if len(rows) > base.TopK {
+		rows = rows[:base.TopK]
+	}
+	return rows, nil
+}
+
+// reciprocalRankFusion merges ranked lists of rows into a single ranking, scoring each row by the
+// sum of 1/(rrfK+rank) over the lists it appears in. The "$dist" of each row is set to its score.
+func reciprocalRankFusion(rankings ...[]Row) []Row {
+	var (
+		fused  []Row
+		scores = make(map[string]float64)
+		byID   = make(map[string]Row)
+	)
+	for _, rows := range rankings {
+		for rank, row := range rows {
+			id := fmt.Sprint(row["id"])
+			if _, ok := byID[id]; !ok {
+				byID[id] = row
+				fused = append(fused, row)
+			}
+			scores[id] += 1 / float64(rrfK+rank+1)
+		}
+	}
+	slices.SortStableFunc(fused, func(a, b Row) int {
+		return cmp.Compare(scores[fmt.Sprint(b["id"])], scores[fmt.Sprint(a["id"])])
+	})
+	for _, row := range fused {
+		row["$dist"] = scores[fmt.Sprint(row["id"])]
+	}
+	return fused
+}
+
+// groupFaces groups rows into results by the groupBy attribute (card_id or printing_id), keeping
+// the rank order of each result's best face, and returns the first topk results.
+func groupFaces(rows []Row, topk int, groupBy string) []SearchResult {
+	var (
+		results []SearchResult
+		byGroup = make(map[string]int)
+	)
+	for _, row := range rows {
+		score, _ := row["$dist"].(float64)
+		delete(row, "$dist")
+
+		group, _ := row[groupBy].(string)
+		if i, ok := byGroup[group]; ok && group != "" {
+			results[i].Faces = append(results[i].Faces, row)
+			continue
+		}
+		if len(results) == topk {
+			continue
+		}
+		id, _ := row["card_id"].(string)
+		printing, _ := row["printing_id"].(string)
+		name, _ := row["name"].(string)
+		layout, _ := row["layout"].(string)
+		byGroup[group] = len(results)
+		results = append(results, SearchResult{
+			CardID:     id,
+			PrintingID: printing,
+			Name:       name,
+			Layout:     layout,
+			Score:      score,
+			Faces:      []Row{row},
+		})
+	}
+	return results
+}
+
+// groupBy returns the attribute shared by the faces of a result: card_id, or printing_id in
+// printing indexes.
+func (idx *Index) groupBy() string {
+	if idx.Mode == PrintingMode {
+		return "printing_id"
+	}
+	return "card_id"
+}
+
+// searchAttributes returns the attributes to include for each face in search results, including
+// the name, type and text of cards in lang, if non-nil, and extra.
+func (idx *Index) searchAttributes(lang *Language, extra []string) []string {
+	attrs := searchAttributes
+	if idx.Mode == PrintingMode {
+		attrs = slices.Concat(attrs, printingSearchAttributes)
+	}
+	if lang != nil {
+		attrs = slices.Concat(attrs, []string{lang.attr("name"), lang.attr("type"), lang.attr("text")})
+	}
+	for _, attr := range extra {
+		if !slices.Contains(attrs, attr) {
+			// Clipped, so as not to append to searchAttributes itself.
+			attrs = append(slices.Clip(attrs), attr)
+		}
+	}
+	return attrs
+}
+
+// Attributes returns the name of every attribute of the rows of the index, sorted.
+func (idx *Index) Attributes() []string {
+	schema := turbopufferSchema()
+	if idx.Mode == PrintingMode {
+		schema = printingSchema()
+	}
+	return slices.Sorted(maps.Keys(schema))
+}
+
+// fetchFaces fills in the faces of multi-faced cards which didn't match the query themselves, with
+// the given attributes. In printing indexes, the faces are those of the result's printing.
+func (idx *Index) fetchFaces(
+	ctx context.Context,
+	backend Backend,
+	results []SearchResult,
+	attrs []string,
+) error {
+	groupBy := idx.groupBy()
+	resultID := func(result SearchResult) string {
+		if groupBy == "printing_id" {
+			return result.PrintingID
+		}
+		return result.CardID
+	}
+	var ids []string
+	for _, result := range results {
+		// mtgjson only sets a side on cards with multiple faces.
+		if side, _ := result.Faces[0]["side"].(string); side != "" && resultID(result) != "" {
+			ids = append(ids, resultID(result))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	filter := filterIn(groupBy, ids)
+	rows, err := backend.Query(ctx, idx.Namespace, QueryRequest{
+		Filters:           &filter,
+		TopK:              len(ids) * maxCardFaces,
+		IncludeAttributes: attrs,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("querying namespace %q: %w", idx.Namespace, err)
+		return fmt.Errorf("fetching faces from namespace %q: %w", idx.Namespace, err)
+	}
+
+	faces := make(map[string][]Row)
+	for _, row := range rows {
+		delete(row, "$dist")
+		id, _ := row[groupBy].(string)
+		faces[id] = append(faces[id], row)
 	}
-	return resp.Rows, nil
+	for i := range results {
+		if all, ok := faces[resultID(results[i])]; ok {
+			results[i].Faces = all
+		}
+		slices.SortStableFunc(results[i].Faces, func(a, b Row) int {
+			sideA, _ := a["side"].(string)
+			sideB, _ := b["side"].(string)
+			return strings.Compare(sideA, sideB)
+		})
+	}
+	return nil
 }
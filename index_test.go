@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRowID(t *testing.T) {
+	fixture, _ := loadFixture(t)
+
+	seen := make(map[string]string)
+	for name, faces := range fixture.Data {
+		for _, face := range faces {
+			id := rowID(name, face)
+			if _, err := uuid.Parse(id); err != nil {
+				t.Errorf("row id %q for %q is not a uuid: %v", id, name, err)
+			}
+			if other, ok := seen[id]; ok {
+				t.Errorf("faces %q and %q share row id %q", other, name, id)
+			}
+			seen[id] = name
+			if again := rowID(name, face); again != id {
+				t.Errorf("row id for %q is unstable: %q then %q", name, id, again)
+			}
+		}
+	}
+
+	// Errata doesn't change a card's identity.
+	opt := fixture.Data["Opt"][0]
+	text := "Scry 2.\nDraw a card."
+	errata := opt
+	errata.Text = &text
+	if rowID("Opt", errata) != rowID("Opt", opt) {
+		t.Error("changing a card's text changed its row id")
+	}
+
+	// Without an oracle ID, the name is used.
+	opt.Identifiers.ScryfallOracleId = nil
+	if rowID("Opt", opt) == rowID("Opt (Alchemy)", opt) {
+		t.Error("cards without oracle ids but with different names share a row id")
+	}
+
+	// A meld result is a face of two cards, with its own oracle ID. It's a row of each card, and
+	// every face of a card shares its card ID.
+	bruna, brisela := fixture.Data["Opt"][0], fixture.Data["Opt"][0]
+	brunaOracle, briselaOracle, a, b := "bruna-oracle", "brisela-oracle", "a", "b"
+	bruna.Identifiers.ScryfallOracleId, bruna.Side = &brunaOracle, &a
+	brisela.Identifiers.ScryfallOracleId, brisela.Side = &briselaOracle, &b
+	const (
+		brunaName  = "Bruna, the Fading Light // Brisela, Voice of Nightmares"
+		giselaName = "Gisela, the Broken Blade // Brisela, Voice of Nightmares"
+	)
+	if rowID(brunaName, brisela) == rowID(giselaName, brisela) {
+		t.Error("the meld result of two cards has one row id")
+	}
+	if buildRow("1", brunaName, bruna)["card_id"] != buildRow("2", brunaName, brisela)["card_id"] {
+		t.Error("faces of a meld card with different oracle ids have different card ids")
+	}
+}
+
+func TestSearchGroupsFaces(t *testing.T) {
+	fixture, _ := loadFixture(t)
+	backend := newMemoryBackend("")
+	index := &Index{Name: "cards", Namespace: "mtg_cards"}
+	if _, err := upsertSet(t.Context(), backend, index.Namespace, atomicEntries(fixture), nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the Ice half of Fire // Ice taps permanents, but both halves are returned.
+	results, err := index.Search(t.Context(), backend, SearchRequest{Query: "tap target permanent", TopK: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	got := results[0]
+	if got.Name != "Fire // Ice" || got.Layout != "split" || got.Score <= 0 {
+		t.Errorf("got %s (layout %q, score %v), want Fire // Ice", got.Name, got.Layout, got.Score)
+	}
+	var faceNames []string
+	for _, face := range got.Faces {
+		name, _ := face["face_name"].(string)
+		faceNames = append(faceNames, name)
+	}
+	if len(faceNames) != 2 || faceNames[0] != "Fire" || faceNames[1] != "Ice" {
+		t.Errorf("got faces %v, want [Fire Ice]", faceNames)
+	}
+
+	// Both faces of Delver of Secrets match "delver", but it's still one card.
+	results, err = index.Search(t.Context(), backend, SearchRequest{Query: "delver", TopK: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Faces) != 2 {
+		t.Errorf("searching for delver: got %d results, want 1 with 2 faces", len(results))
+	}
+}
+
+func TestBuildRow(t *testing.T) {
+	fixture, _ := loadFixture(t)
+
+	mox := buildRow("id", "Mox Diamond", fixture.Data["Mox Diamond"][0])
+	if mox["is_reserved"] != true {
+		t.Errorf("Mox Diamond is_reserved = %v, want true", mox["is_reserved"])
+	}
+	legal, _ := mox["legal_formats"].([]string)
+	if fmt.Sprint(legal) != "[commander legacy vintage]" {
+		t.Errorf("Mox Diamond legal_formats = %v, want [commander legacy vintage]", legal)
+	}
+
+	cobra := buildRow("id", "Lotus Cobra", fixture.Data["Lotus Cobra"][0])
+	if keywords, _ := cobra["keywords"].([]string); fmt.Sprint(keywords) != "[Landfall]" {
+		t.Errorf("Lotus Cobra keywords = %v, want [Landfall]", keywords)
+	}
+	if cobra["is_reserved"] != false {
+		t.Errorf("Lotus Cobra is_reserved = %v, want false", cobra["is_reserved"])
+	}
+
+	// Every attribute written must be described by the schema.
+	schema := turbopufferSchema()
+	for attr := range cobra {
+		if _, ok := schema[attr]; !ok {
+			t.Errorf("attribute %q is missing from the schema", attr)
+		}
+	}
+}
+
+func TestSearchModes(t *testing.T) {
+	fixture, _ := loadFixture(t)
+	embedder, err := newEmbedder(HashEmbedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := newMemoryBackend("")
+	index := &Index{Name: "cards", Namespace: "mtg_cards", Embedder: HashEmbedder}
+	if _, err := upsertSet(t.Context(), backend, index.Namespace, atomicEntries(fixture), nil, embedder, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// "scrying" never appears verbatim, so only the vector stage can find Opt ("Scry 1.").
+	for _, tc := range []struct {
+		mode SearchMode
+		want string
+	}{
+		{mode: KeywordSearch, want: ""},
+		{mode: VectorSearch, want: "Opt"},
+		{mode: HybridSearch, want: "Opt"},
+		{mode: "", want: "Opt"},
+	} {
+		results, err := index.Search(t.Context(), backend, SearchRequest{Query: "scrying", Mode: tc.mode, TopK: 3})
+		if err != nil {
+			t.Fatalf("%q search: %v", tc.mode, err)
+		}
+		var got string
+		if len(results) > 0 {
+			got = results[0].Name
+		}
+		if got != tc.want {
+			t.Errorf("%q search for scrying: got %q first, want %q", tc.mode, got, tc.want)
+		}
+	}
+
+	// Exact matches still rank first in hybrid search.
+	results, err := index.Search(t.Context(), backend, SearchRequest{Query: "landfall", Mode: HybridSearch, TopK: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0].Name != "Lotus Cobra" {
+		t.Errorf("hybrid search for landfall: got %v, want Lotus Cobra first", results)
+	}
+
+	// Filters apply to the vector stage too.
+	results, err = index.Search(t.Context(), backend, SearchRequest{
+		Query:   "scrying",
+		Mode:    VectorSearch,
+		Filters: SearchFilters{Types: []string{"creature"}},
+		TopK:    10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Name == "Opt" {
+			t.Errorf("vector search for creatures returned Opt")
+		}
+	}
+
+	unembedded := &Index{Name: "cards", Namespace: "mtg_cards"}
+	if _, err := unembedded.Search(t.Context(), backend, SearchRequest{Query: "scrying", Mode: VectorSearch, TopK: 3}); err == nil {
+		t.Error("vector search of an index without an embedder succeeded, want an error")
+	}
+}
+
+func TestReciprocalRankFusion(t *testing.T) {
+	rows := func(ids ...string) []Row {
+		var out []Row
+		for _, id := range ids {
+			out = append(out, Row{"id": id})
+		}
+		return out
+	}
+	fused := reciprocalRankFusion(rows("a", "b", "c"), rows("c", "d", "b"))
+
+	var got []string
+	for _, row := range fused {
+		got = append(got, row["id"].(string))
+	}
+	// b and c appear in both rankings, so they beat a and d, which each appear once.
+	if want := []string{"c", "b", "a", "d"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if score, want := fused[0]["$dist"].(float64), 1.0/61+1.0/63; score != want {
+		t.Errorf("got score %v for c, want %v", score, want)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/turbopuffer/turbopuffer-go"
+)
+
+// Language is a language other than English which cards are printed in. mtgjson carries the
+// names, types and text of cards in each language as foreign data, which is indexed alongside the
+// English attributes, e.g. as name_de, type_de and text_de for German.
+type Language struct {
+	// Code identifies the language in attribute names and search requests, e.g. "de".
+	Code string
+
+	// Name is mtgjson's name for the language, e.g. "German".
+	Name string
+
+	// Stemmer is the turbopuffer language used to stem and tokenize text in the language. Empty for
+	// languages turbopuffer can't stem (Chinese, Japanese and Korean), which are matched by their
+	// unstemmed words instead.
+	Stemmer string
+
+	// Segmented is set for languages which don't separate words with spaces (Chinese and
+	// Japanese), which turbopuffer's tokenizer would take whole sentences as words of. Their text
+	// is searched in separate attributes, see searchAttr, holding its characters separated by
+	// spaces, see segment.
+	Segmented bool
+}
+
+// languages are the languages indexed from foreign data.
+var languages = []Language{
+	{Code: "de", Name: "German", Stemmer: "german"},
+	{Code: "fr", Name: "French", Stemmer: "french"},
+	{Code: "it", Name: "Italian", Stemmer: "italian"},
+	{Code: "es", Name: "Spanish", Stemmer: "spanish"},
+	{Code: "pt", Name: "Portuguese (Brazil)", Stemmer: "portuguese"},
+	{Code: "ru", Name: "Russian", Stemmer: "russian"},
+	{Code: "ja", Name: "Japanese", Segmented: true},
+	{Code: "ko", Name: "Korean"},
+	{Code: "zhs", Name: "Chinese Simplified", Segmented: true},
+	{Code: "zht", Name: "Chinese Traditional", Segmented: true},
+}
+
+// lookupLanguage returns the language with the given code. English ("en" or empty) has no foreign
+// data, so it's returned as nil.
+func lookupLanguage(code string) (*Language, error) {
+	if code == "" || code == "en" {
+		return nil, nil
+	}
+	codes := []string{"en"}
+	for i := range languages {
+		if languages[i].Code == code {
+			return &languages[i], nil
+		}
+		codes = append(codes, languages[i].Code)
+	}
+	return nil, fmt.Errorf("unknown language %q, must be one of %s", code, strings.Join(codes, ", "))
+}
+
+// attr returns the name of the attribute holding base (name, type or text) in the language.
+func (l *Language) attr(base string) string {
+	return base + "_" + l.Code
+}
+
+// searchAttr returns the name of the full-text attribute which base (name, type or text) in the
+// language is searched in: attr, or for Segmented languages, an attribute of its own holding the
+// segmented text.
+func (l *Language) searchAttr(base string) string {
+	if l.Segmented {
+		return l.attr(base) + "_terms"
+	}
+	return l.attr(base)
+}
+
+// segment separates the terms of text in the language with spaces, so any tokenizer which splits
+// on whitespace finds the same terms as tokenize. Text in other languages is returned as is.
+func (l *Language) segment(text string) string {
+	if !l.Segmented {
+		return text
+	}
+	return strings.Join(tokenize(text), " ")
+}
+
+// addForeignData adds the attributes of each indexed language the card has foreign data in to
+// row.
+func addForeignData(row Row, card AtomicCard) {
+	for _, data := range card.ForeignData {
+		for i := range languages {
+			lang := &languages[i]
+			if data.Language != lang.Name {
+				continue
+			}
+			row[lang.attr("name")] = data.Name
+			row[lang.attr("type")] = data.Type
+			row[lang.attr("text")] = data.Text
+			if lang.Segmented {
+				row[lang.searchAttr("name")] = lang.segment(data.Name)
+				if data.Type != nil {
+					row[lang.searchAttr("type")] = lang.segment(*data.Type)
+				}
+				if data.Text != nil {
+					row[lang.searchAttr("text")] = lang.segment(*data.Text)
+				}
+			}
+		}
+	}
+}
+
+// addForeignSchema adds the attributes of every indexed language to schema. For Segmented
+// languages, only the attributes holding the segmented text are full-text searchable.
+func addForeignSchema(schema Schema) {
+	for _, lang := range languages {
+		config := &turbopuffer.FullTextSearchConfigParam{
+			Stemming:        turbopuffer.Bool(lang.Stemmer != ""),
+			RemoveStopwords: turbopuffer.Bool(false),
+		}
+		if lang.Stemmer != "" {
+			config.Language = turbopuffer.Language(lang.Stemmer)
+		}
+		for _, base := range []string{"name", "type", "text"} {
+			schema[lang.attr(base)] = turbopuffer.AttributeSchemaConfigParam{
+				Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+			}
+			schema[lang.searchAttr(base)] = turbopuffer.AttributeSchemaConfigParam{
+				Type:           turbopuffer.Opt(turbopuffer.AttributeType("string")),
+				FullTextSearch: config,
+			}
+		}
+	}
+}
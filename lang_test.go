@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestForeignSearch(t *testing.T) {
+	fixture, _ := loadFixture(t)
+	backend := newMemoryBackend("")
+	index := &Index{Name: "cards", Namespace: "mtg_cards", Embedder: HashEmbedder}
+	embedder, err := newEmbedder(HashEmbedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upsertSet(t.Context(), backend, index.Namespace, atomicEntries(fixture), nil, embedder, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		lang, query, want string
+	}{
+		{lang: "de", query: "elfen", want: "Llanowar Elves"},
+		{lang: "de", query: "zerstöre kreatur", want: "Murder"},
+		{lang: "de", query: "eis", want: "Fire // Ice"},
+		{lang: "ja", query: "殺害", want: "Murder"},
+		{lang: "ja", query: "エルフ", want: "Llanowar Elves"},
+		{lang: "en", query: "mord", want: ""},
+		{lang: "", query: "mord", want: ""},
+		{lang: "fr", query: "mord", want: ""},
+	} {
+		results, err := index.Search(t.Context(), backend, SearchRequest{Query: tc.query, Mode: KeywordSearch, Lang: tc.lang, TopK: 3})
+		if err != nil {
+			t.Errorf("searching for %q in %q: %v", tc.query, tc.lang, err)
+			continue
+		}
+		var got string
+		if len(results) > 0 {
+			got = results[0].Name
+		}
+		if got != tc.want {
+			t.Errorf("searching for %q in %q: got %q first, want %q", tc.query, tc.lang, got, tc.want)
+		}
+	}
+
+	// Foreign names come back with the English card, and every face is still fetched.
+	results, err := index.Search(t.Context(), backend, SearchRequest{Query: "eis", Lang: "de", TopK: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Faces) != 2 || results[0].Faces[0]["name_de"] != "Feuer // Eis" {
+		t.Errorf("searching for eis in German: got %+v, want both faces of Fire // Ice with German names", results)
+	}
+
+	if _, err := index.Search(t.Context(), backend, SearchRequest{Query: "mord", Lang: "xx", TopK: 3}); err == nil {
+		t.Error("searching in an unknown language succeeded")
+	}
+	if _, err := index.SearchMode(VectorSearch, "de"); err == nil {
+		t.Error("vector search in German succeeded, want an error")
+	}
+	if mode, err := index.SearchMode("", "ja"); err != nil || mode != KeywordSearch {
+		t.Errorf("default search mode in Japanese = %q (err %v), want bm25", mode, err)
+	}
+}
+
+func TestSegmentedLanguages(t *testing.T) {
+	fixture, _ := loadFixture(t)
+	ja, err := lookupLanguage("ja")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Japanese is shown as is, but searched with its characters separated, which turbopuffer's
+	// tokenizer splits the same way as the memory backend's.
+	row := buildRow("id", "Llanowar Elves", fixture.Data["Llanowar Elves"][0])
+	if row["name_ja"] != "ラノワールのエルフ" || row["name_ja_terms"] != "ラ ノ ワ ー ル の エ ル フ" {
+		t.Errorf("got name_ja %q and name_ja_terms %q, want the name and its characters", row["name_ja"], row["name_ja_terms"])
+	}
+	if _, ok := row["name_de_terms"]; ok {
+		t.Error("German, which separates words with spaces, has segmented attributes")
+	}
+	schema := turbopufferSchema()
+	if schema["name_ja"].FullTextSearch != nil || schema["name_ja_terms"].FullTextSearch == nil {
+		t.Error("only the segmented Japanese attributes should be full-text searchable")
+	}
+
+	query := keywordQuery(QueryRequest{}, "エルフ", ja, rankingProfiles[DefaultProfile])
+	if query.Text != "エ ル フ" || query.Fields[0].Attribute != "name_ja_terms" {
+		t.Errorf("got query %q on %v, want the segmented query on the segmented attributes", query.Text, query.Fields)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	for text, want := range map[string][]string{
+		"Draw a card.":        {"draw", "a", "card"},
+		"Llanowar-Elfen":      {"llanowar", "elfen"},
+		"ラノワールのエルフ":           {"ラ", "ノ", "ワ", "ー", "ル", "の", "エ", "ル", "フ"},
+		"{T}：マナ・プールに{G}を加える。": {"t", "マ", "ナ", "プ", "ー", "ル", "に", "g", "を", "加", "え", "る"},
+		"Zerstöre 1 Kreatur":  {"zerstöre", "1", "kreatur"},
+	} {
+		if got := tokenize(text); !slices.Equal(got, want) {
+			t.Errorf("tokenize(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -2,79 +2,128 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"context"
-	"flag"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
-
-	"github.com/turbopuffer/turbopuffer-go"
-	"github.com/turbopuffer/turbopuffer-go/option"
 )
 
 func main() {
-	flag.Parse()
+	// Cancelling the context on interrupt lets a build clean up after itself before exiting.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(code)
+}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer cancel()
+func buildIndex(ctx context.Context, backend Backend, name string) error {
+	if existing, err := LoadIndex(name); err != nil {
+		return fmt.Errorf("checking for existing index: %w", err)
+	} else if existing != nil {
+		return fmt.Errorf(
+			"index %q already exists at %q, not overwriting it; to delete it fully (including from turbopuffer), use: puffingmtg delete %q",
+			name,
+			indexFilepath(name),
+			name,
+		)
+	}
+	if alias, err := LoadAlias(name); err != nil {
+		return fmt.Errorf("checking for alias named %q: %w", name, err)
+	} else if alias != nil {
+		return fmt.Errorf("%q is already the name of an alias, pick another name for the index", name)
+	}
 
-	tpuf, err := newTurbopufferClient()
+	set, err := mtgSet()
 	if err != nil {
-		log.Fatalf("failed to create turbopuffer client: %v", err)
+		return fmt.Errorf("choosing mtg set: %w", err)
 	}
 
-	switch {
-	case *flagBuildIndex != "":
-		if err := buildIndex(ctx, tpuf, *flagBuildIndex); err != nil {
-			log.Fatalf("failed to build index %q: %v", *flagBuildIndex, err)
+	embedder, err := embedderKind()
+	if err != nil {
+		return fmt.Errorf("choosing embedder: %w", err)
+	}
+
+	mode, err := indexMode()
+	if err != nil {
+		return fmt.Errorf("choosing index mode: %w", err)
+	}
+
+	// A staging file which can't even be read is still a sign of an interrupted build. If the
+	// build checkpointed its progress, it's resumed.
+	var index *Index
+	if staged, err := LoadStagedIndex(name); err != nil || staged != nil {
+		checkpoint, checkpointErr := LoadCheckpoint(name)
+		if err != nil || checkpointErr != nil || checkpoint == nil {
+			return fmt.Errorf(
+				"index %q is already being built, or a previous build of it was interrupted; to clean up an interrupted build (including from turbopuffer), use: puffingmtg delete %q",
+				name,
+				name,
+			)
 		}
-	case *flagDeleteIndex != "":
-		if err := deleteIndex(ctx, tpuf, *flagDeleteIndex); err != nil {
-			log.Fatalf("failed to delete index %q: %v", *flagDeleteIndex, err)
+		if staged.Set != set || cmp.Or(staged.Mode, CardMode) != mode || staged.Embedder != embedder {
+			return fmt.Errorf(
+				"interrupted build of index %q is of set %q in mode %q with embedder %q, resume it with the same flags or delete it with puffingmtg delete",
+				name,
+				staged.Set,
+				cmp.Or(staged.Mode, CardMode),
+				staged.Embedder,
+			)
 		}
-	case *flagServeIndex != "":
-		if err := serveIndex(ctx, tpuf, *flagServeIndex); err != nil {
-			log.Fatalf("failed to serve index %q: %v", *flagServeIndex, err)
+		index, err = ResumeIndex(ctx, backend, name, *flagSource)
+		if err != nil {
+			return fmt.Errorf("resuming build of index: %w", err)
+		}
+	} else {
+		index, err = NewIndex(ctx, backend, name, set, *flagSource, embedder, mode)
+		if err != nil {
+			return fmt.Errorf("creating new index: %w", err)
 		}
-	default:
-		log.Println(
-			"no action specified, you must pass one of: -build-index, -delete-index or -serve-index",
-		)
-		log.Println("available flags:")
-		flag.PrintDefaults()
 	}
-}
 
-func buildIndex(ctx context.Context, tpuf *turbopuffer.Client, name string) error {
-	fp := fmt.Sprintf("%s.json", name)
-	if existing, err := LoadIndex(fp); err != nil {
-		return fmt.Errorf("checking for existing index: %w", err)
-	} else if existing != nil {
-		log.Printf("index %q already exists, not overwriting.", fp)
-		log.Printf("to delete this index fully (including from turbopuffer), use -delete-index %q", name)
-		return nil
-	}
+	log.Printf("successfully created index %q (backed by tpuf namespace %q)", name, index.Namespace)
+	log.Printf("to serve this index, use: puffingmtg serve %q", name)
 
-	set, err := mtgSet()
+	return nil
+}
+
+func refreshIndex(ctx context.Context, backend Backend, name string) error {
+	index, err := LoadIndex(name)
 	if err != nil {
-		return fmt.Errorf("choosing mtg set: %w", err)
+		return fmt.Errorf("loading index %q: %w", name, err)
+	} else if index == nil {
+		return fmt.Errorf("index %q does not exist, cannot refresh. run puffingmtg build first", name)
 	}
 
-	index, err := NewIndex(ctx, tpuf, name, set)
+	changed, err := index.Refresh(ctx, backend, *flagSource)
 	if err != nil {
-		return fmt.Errorf("creating new index: %w", err)
+		return fmt.Errorf("refreshing index %q: %w", name, err)
 	}
 
-	log.Printf("successfully created index %q (backed by tpuf namespace %q)", name, index.Namespace)
-	log.Printf("to serve this index, use -serve-index %q", name)
+	if changed {
+		log.Printf("successfully refreshed index %q (mtgjson version %s)", name, index.Version)
+	} else {
+		log.Printf("index %q is already up to date, nothing to do", name)
+	}
 
 	return nil
 }
 
-func deleteIndex(ctx context.Context, tpuf *turbopuffer.Client, name string) error {
+func deleteIndex(ctx context.Context, backend Backend, name string) error {
+	if cleaned, err := DeleteStagedIndex(ctx, backend, name); err != nil {
+		return fmt.Errorf("cleaning up interrupted build of index %q: %w", name, err)
+	} else if cleaned {
+		log.Printf("cleaned up interrupted build of index %q", name)
+	}
+
 	index, err := LoadIndex(name)
 	if err != nil {
 		return fmt.Errorf("loading index %q: %w", name, err)
@@ -83,7 +132,21 @@ func deleteIndex(ctx context.Context, tpuf *turbopuffer.Client, name string) err
 		return nil
 	}
 
-	if err := index.Delete(ctx, tpuf); err != nil {
+	current, previous, err := aliasesOf(name)
+	if err != nil {
+		return fmt.Errorf("checking aliases of index %q: %w", name, err)
+	} else if len(current) > 0 {
+		return fmt.Errorf(
+			"index %q is served by alias %q, promote another index to it (or roll it back) first",
+			name,
+			current[0],
+		)
+	}
+	for _, alias := range previous {
+		log.Printf("alias %q will no longer be able to roll back to index %q", alias, name)
+	}
+
+	if err := index.Delete(ctx, backend); err != nil {
 		return fmt.Errorf("deleting index %q: %w", name, err)
 	}
 
@@ -92,14 +155,310 @@ func deleteIndex(ctx context.Context, tpuf *turbopuffer.Client, name string) err
 	return nil
 }
 
-func serveIndex(ctx context.Context, tpuf *turbopuffer.Client, name string) error {
+func serveIndex(ctx context.Context, backend Backend, name string) error {
+	live, err := resolveIndex(name)
+	if err != nil {
+		return fmt.Errorf("%w. run puffingmtg build first", err)
+	}
+	if live.alias != "" {
+		log.Printf("alias %q points at index %q, following it for promotions", name, live.Load().Name)
+		go live.watch(ctx, aliasPollInterval)
+	}
+
+	log.Printf("serving index %q on http://%s", name, *flagListen)
+	return listenAndServe(ctx, *flagListen, newServer(backend, live))
+}
+
+// searchIndex searches an index (or alias) for query, writing the results to w in the format of
+// -format. If query is empty, searches interactively instead, see replIndex.
+func searchIndex(ctx context.Context, backend Backend, name, query string, w io.Writer) error {
+	live, err := resolveIndex(name)
+	if err != nil {
+		return fmt.Errorf("%w. run puffingmtg build first", err)
+	}
+	if query == "" {
+		if live.alias != "" {
+			go live.watch(ctx, aliasPollInterval)
+		}
+		return replIndex(ctx, backend, live)
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	index := live.Load()
+	fields, err := searchFields(index)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseQuery(query)
+	if perr := (*ParseError)(nil); errors.As(err, &perr) {
+		return fmt.Errorf("invalid query: %s\n%s", perr.Msg, perr.Pointer())
+	} else if err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+	req, err := searchRequest(index, parsed)
+	if err != nil {
+		return err
+	}
+	req.Attributes = resultAttributes(fields)
+
+	results, err := index.Search(ctx, backend, req)
+	if err != nil {
+		return fmt.Errorf("searching index %q: %w", index.Name, err)
+	}
+	return writeResults(w, format, fields, results)
+}
+
+// searchRequest returns the request to search an index for a query, as set by -k, -mode, -lang,
+// -collapse and -profile.
+func searchRequest(index *Index, query ParsedQuery) (SearchRequest, error) {
+	topk, err := searchTopK()
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	mode, err := index.SearchMode(SearchMode(*flagSearchMode), *flagLang)
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	profile, err := LookupProfile(*flagRankingProfile)
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	return SearchRequest{
+		Query:    query.Text,
+		Mode:     mode,
+		Lang:     *flagLang,
+		Filters:  query.Filters,
+		TopK:     topk,
+		Collapse: *flagCollapse,
+		Profile:  &profile,
+	}, nil
+}
+
+// promoteAlias points an alias at an index, as given by puffingmtg promote ALIAS=INDEX.
+func promoteAlias(arg string) error {
+	name, index, ok := strings.Cut(arg, "=")
+	if !ok || name == "" || index == "" {
+		return errors.New("expected ALIAS=INDEX, e.g. standard=standard-20261016")
+	}
+
+	alias, err := PromoteAlias(name, index)
+	if err != nil {
+		return err
+	}
+
+	if len(alias.History) > 0 {
+		previous := alias.History[len(alias.History)-1]
+		log.Printf("alias %q now points at index %q (was %q)", name, index, previous)
+		log.Printf("to undo this, use: puffingmtg rollback %q", name)
+	} else {
+		log.Printf("alias %q now points at index %q", name, index)
+	}
+	log.Printf("servers of alias %q will switch to the new index within %s", name, aliasPollInterval)
+
+	return nil
+}
+
+func rollbackAlias(name string) error {
+	alias, err := RollbackAlias(name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("alias %q points at index %q again", name, alias.Index)
+	log.Printf("servers of alias %q will switch back within %s", name, aliasPollInterval)
+
+	return nil
+}
+
+// listIndexes writes a table of every index in the index directory to w, along with the size of
+// its namespace.
+func listIndexes(ctx context.Context, backend Backend, w io.Writer) error {
+	indexes, err := ListIndexes()
+	if err != nil {
+		return err
+	}
+	legacy, err := legacyIndexFiles()
+	if err != nil {
+		return err
+	}
+	for _, fp := range legacy {
+		log.Printf("index file %q is in the current directory, it must be moved to %q", fp, indexDir())
+	}
+	if len(indexes) == 0 {
+		log.Printf("no indexes in %q, create one with puffingmtg build", indexDir())
+		return nil
+	}
+	aliases, err := ListAliases()
+	if err != nil {
+		return err
+	}
+	aliasNames := make(map[string][]string)
+	for _, alias := range aliases {
+		aliasNames[alias.Index] = append(aliasNames[alias.Index], alias.Name)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSET\tSTATE\tVERSION\tCREATED\tROWS\tSIZE\tNAMESPACE\tALIASES")
+	for _, index := range indexes {
+		var rows, size string
+		meta, err := backend.Metadata(ctx, index.Namespace)
+		switch {
+		case errors.Is(err, ErrNamespaceNotFound):
+			rows, size = "missing", "missing"
+		case err != nil:
+			return fmt.Errorf("getting metadata of namespace %q: %w", index.Namespace, err)
+		default:
+			rows, size = strconv.FormatInt(meta.ApproxRowCount, 10), formatBytes(meta.ApproxLogicalBytes)
+		}
+		created := "-"
+		if !index.CreatedAt.IsZero() {
+			created = index.CreatedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			index.Name,
+			index.Set,
+			cmp.Or(index.State, IndexReady),
+			cmp.Or(index.Version, "-"),
+			created,
+			rows,
+			size,
+			index.Namespace,
+			cmp.Or(strings.Join(aliasNames[index.Name], ","), "-"),
+		)
+	}
+	return tw.Flush()
+}
+
+// describeIndex writes everything known about an index to w: its metadata, and that of its
+// namespace.
+func describeIndex(ctx context.Context, backend Backend, name string, w io.Writer) error {
 	index, err := LoadIndex(name)
 	if err != nil {
 		return fmt.Errorf("loading index %q: %w", name, err)
 	} else if index == nil {
-		return fmt.Errorf("index %q does not exist, cannot serve. run -build-index first", name)
+		if index, err = LoadStagedIndex(name); err != nil || index == nil {
+			return fmt.Errorf("index %q does not exist in %q", name, indexDir())
+		}
 	}
 
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	field := func(label string, value any) {
+		fmt.Fprintf(tw, "%s:\t%v\n", label, value)
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	field("name", index.Name)
+	field("state", cmp.Or(index.State, IndexReady))
+	field("set", index.Set)
+	field("mode", cmp.Or(index.Mode, CardMode))
+	field("mtgjson version", cmp.Or(index.Version, "-"))
+	field("checksum", cmp.Or(index.Checksum, "-"))
+	field("embedder", cmp.Or(index.Embedder, NoEmbedder))
+	field("created", formatTime(index.CreatedAt))
+	if index.UpdatedAt != nil {
+		field("refreshed", formatTime(*index.UpdatedAt))
+	}
+	if index.State == IndexBuilding {
+		if checkpoint, err := LoadCheckpoint(index.Name); err == nil && checkpoint != nil {
+			field("checkpoint", fmt.Sprintf(
+				"%d cards written, at %s",
+				checkpoint.Written(),
+				formatTime(checkpoint.UpdatedAt),
+			))
+		}
+	}
+	if index.Mode == PrintingMode {
+		field("printings", len(index.Cards))
+	} else {
+		field("cards", len(index.Cards))
+	}
+	current, previous, err := aliasesOf(index.Name)
+	if err != nil {
+		return err
+	}
+	if len(current) > 0 {
+		field("aliases", strings.Join(current, ", "))
+	}
+	if len(previous) > 0 {
+		field("previously aliased by", strings.Join(previous, ", "))
+	}
+	field("namespace", index.Namespace)
+
+	meta, err := backend.Metadata(ctx, index.Namespace)
+	switch {
+	case errors.Is(err, ErrNamespaceNotFound):
+		field("namespace rows", "missing (the namespace does not exist)")
+	case err != nil:
+		return fmt.Errorf("getting metadata of namespace %q: %w", index.Namespace, err)
+	default:
+		field("namespace rows", meta.ApproxRowCount)
+		field("namespace size", formatBytes(meta.ApproxLogicalBytes))
+		field("namespace created", formatTime(meta.CreatedAt))
+	}
+	return tw.Flush()
+}
+
+// gcNamespaces deletes orphaned namespaces, after listing them and asking for confirmation (unless
+// -yes is set).
+func gcNamespaces(ctx context.Context, backend Backend) error {
+	orphans, err := findOrphanedNamespaces(ctx, backend)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		log.Printf("no orphaned namespaces found, nothing to do")
+		return nil
+	}
+
+	var rows, size int64
+	log.Printf("found %d orphaned namespaces:", len(orphans))
+	for _, orphan := range orphans {
+		log.Printf(
+			"  %s: %d rows, %s, created %s",
+			orphan.Name,
+			orphan.Metadata.ApproxRowCount,
+			formatBytes(orphan.Metadata.ApproxLogicalBytes),
+			orphan.Metadata.CreatedAt.Format(time.RFC3339),
+		)
+		rows += orphan.Metadata.ApproxRowCount
+		size += orphan.Metadata.ApproxLogicalBytes
+	}
+	log.Printf("in total: %d rows, %s", rows, formatBytes(size))
+
+	if !*flagYes {
+		ok, err := confirm(fmt.Sprintf("delete these %d namespaces?", len(orphans)))
+		if err != nil {
+			return err
+		} else if !ok {
+			log.Printf("not deleting anything")
+			return nil
+		}
+	}
+
+	for _, orphan := range orphans {
+		if err := backend.DeleteAll(ctx, orphan.Name); err != nil && !errors.Is(err, ErrNamespaceNotFound) {
+			return fmt.Errorf("deleting namespace %q: %w", orphan.Name, err)
+		}
+		log.Printf("deleted namespace %q", orphan.Name)
+	}
+	log.Printf("successfully deleted %d orphaned namespaces", len(orphans))
+
+	return nil
+}
+
+// replIndex reads queries from stdin, one per line, and logs the results of each, searched as set by
+// -k, -mode, -lang and -collapse. Queries are written in Scryfall-style syntax (see ParseQuery).
+func replIndex(ctx context.Context, backend Backend, live *liveIndex) error {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
@@ -108,29 +467,41 @@ func serveIndex(ctx context.Context, tpuf *turbopuffer.Client, name string) erro
 		if err != nil {
 			return fmt.Errorf("reading query from stdin: %w", err)
 		}
-		query = strings.Trim(query, " \r\n")
+		parsed, err := ParseQuery(strings.TrimSpace(query))
+		if perr := (*ParseError)(nil); errors.As(err, &perr) {
+			log.Printf("invalid query: %s\n%s", perr.Msg, perr.Pointer())
+			continue
+		}
+		if parsed.Text == "" && parsed.Filters.Empty() {
+			continue
+		}
 
+		index := live.Load()
+		req, err := searchRequest(index, parsed)
+		if err != nil {
+			return err
+		}
 		start := time.Now()
-		results, err := index.Search(ctx, tpuf, query, 10)
+		results, err := index.Search(ctx, backend, req)
 		if err != nil {
-			return fmt.Errorf("searching index %q: %w", name, err)
+			return fmt.Errorf("searching index %q: %w", index.Name, err)
 		}
 
 		log.Printf("found %d results in %d ms:", len(results), time.Since(start).Milliseconds())
-		for i, row := range results {
-			log.Printf("%d: %s (%s)\n%s", i+1, row["name"], row["mana_cost"], row["text"])
+		for i, result := range results {
+			header := result.Name
+			if set, _ := result.Faces[0]["set_code"].(string); set != "" {
+				// Printing indexes: show which printing matched.
+				header += fmt.Sprintf(" (%s #%s, %s)", set, result.Faces[0]["number"], result.Faces[0]["rarity"])
+			}
+			log.Printf("%d: %s", i+1, header)
+			for _, face := range result.Faces {
+				name := face["name"]
+				if faceName, ok := face["face_name"].(string); ok && faceName != "" {
+					name = faceName
+				}
+				log.Printf("  %s (%s)\n  %s", name, face["mana_cost"], face["text"])
+			}
 		}
 	}
 }
-
-func newTurbopufferClient() (*turbopuffer.Client, error) {
-	apiKey, err := tpufApiKey()
-	if err != nil {
-		return nil, fmt.Errorf("getting turbopuffer api key: %w", err)
-	}
-	client := turbopuffer.NewClient(
-		option.WithAPIKey(apiKey),
-		option.WithRegion(tpufRegion()),
-	)
-	return &client, nil
-}
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixtureSet is a small atomic set in the mtgjson format, served in place of mtgjson.com.
+const fixtureSet = "testdata/StandardAtomic.json"
+
+// setFlag overrides a flag (or any other package-level setting) for the duration of a test.
+func setFlag[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// serveFixtures serves the testdata directory in place of the mtgjson API.
+func serveFixtures(t *testing.T) {
+	t.Helper()
+	// Tests may change directory, so resolve the path now.
+	dir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatalf("resolving testdata directory: %v", err)
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(srv.Close)
+	setFlag(t, &mtgjsonBaseURL, srv.URL)
+}
+
+// newTestBackend configures the flags for the given kind of backend, pointing turbopuffer at a
+// local fake, and returns the resulting backend. For turbopuffer, the fake is returned too.
+func newTestBackend(t *testing.T, kind BackendKind) (Backend, *fakeTurbopuffer) {
+	t.Helper()
+	var fake *fakeTurbopuffer
+	switch kind {
+	case TurbopufferBackend:
+		fake = newFakeTurbopuffer(t)
+		setFlag(t, flagTpufBaseURL, fake.URL)
+		setFlag(t, flagTpufApiKey, "tpuf_test")
+	case MemoryBackend:
+		setFlag(t, flagMemoryDir, t.TempDir())
+	}
+	setFlag(t, flagBackend, string(kind))
+	setFlag(t, flagIndexDir, t.TempDir())
+
+	backend, err := newBackend()
+	if err != nil {
+		t.Fatalf("creating %s backend: %v", kind, err)
+	}
+	return backend, fake
+}
+
+// AtomicSet is a whole atomic file (e.g. StandardAtomic.json), decoded at once. Builds stream
+// cards with streamCards instead, but tests find it easier to change fixtures as a whole.
+type AtomicSet struct {
+	Data map[string][]AtomicCard `json:"data"`
+	Meta Meta                    `json:"meta"`
+}
+
+func loadFixture(t *testing.T) (*AtomicSet, string) {
+	t.Helper()
+	data, err := os.ReadFile(fixtureSet)
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	var set AtomicSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatalf("decoding fixture: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return &set, hex.EncodeToString(sum[:])
+}
+
+// atomicEntries streams the cards of set, for upsertSet.
+func atomicEntries(set *AtomicSet) entryStream[AtomicCard] {
+	return func(yield func(string, []AtomicCard) error) error {
+		for name, faces := range set.Data {
+			if err := yield(name, faces); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("finding a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestIndexLifecycle(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			serveFixtures(t)
+			backend, fake := newTestBackend(t, kind)
+			setFlag(t, flagSet, string(Standard))
+			fixture, checksum := loadFixture(t)
+			t.Chdir(t.TempDir())
+
+			ctx := t.Context()
+
+			if err := buildIndex(ctx, backend, "cards"); err != nil {
+				t.Fatalf("building index: %v", err)
+			}
+			index, err := LoadIndex("cards")
+			if err != nil || index == nil {
+				t.Fatalf("loading built index: %v (index: %v)", err, index)
+			}
+			if index.Set != Standard {
+				t.Errorf("index set = %q, want %q", index.Set, Standard)
+			}
+			if index.Checksum != checksum {
+				t.Errorf("index checksum = %q, want %q", index.Checksum, checksum)
+			}
+
+			var numFaces int64
+			for _, faces := range fixture.Data {
+				numFaces += int64(len(faces))
+			}
+			meta, err := backend.Metadata(ctx, index.Namespace)
+			if err != nil {
+				t.Fatalf("getting namespace metadata: %v", err)
+			}
+			if meta.ApproxRowCount != numFaces {
+				t.Errorf("namespace has %d rows, want %d", meta.ApproxRowCount, numFaces)
+			}
+
+			// Building an index which already exists fails, leaving it alone.
+			if err := buildIndex(ctx, backend, "cards"); err == nil || !strings.Contains(err.Error(), "already exists") {
+				t.Fatalf("rebuilding existing index: got error %v, want it to already exist", err)
+			}
+			if again, _ := LoadIndex("cards"); again.Namespace != index.Namespace {
+				t.Errorf("rebuild replaced namespace %q with %q", index.Namespace, again.Namespace)
+			}
+
+			if fake != nil {
+				writes := fake.Requests("write")
+				if len(writes) == 0 {
+					t.Fatal("no writes sent to turbopuffer")
+				}
+				schema, _ := writes[0].Body["schema"].(map[string]any)
+				if _, ok := schema["text"]; !ok {
+					t.Errorf("write is missing the schema for %q: %v", "text", writes[0].Body["schema"])
+				}
+			}
+
+			testServeIndex(t, backend, "cards")
+
+			if fake != nil && len(fake.Requests("query")) == 0 {
+				t.Error("serving did not send any queries to turbopuffer")
+			}
+
+			if err := deleteIndex(ctx, backend, "cards"); err != nil {
+				t.Fatalf("deleting index: %v", err)
+			}
+			if deleted, err := LoadIndex("cards"); err != nil || deleted != nil {
+				t.Errorf("index file still exists after delete (err: %v)", err)
+			}
+			if _, err := backend.Metadata(ctx, index.Namespace); !errors.Is(err, ErrNamespaceNotFound) {
+				t.Errorf("namespace metadata after delete: got %v, want ErrNamespaceNotFound", err)
+			}
+			if err := deleteIndex(ctx, backend, "cards"); err != nil {
+				t.Errorf("deleting a missing index: %v", err)
+			}
+		})
+	}
+}
+
+func testServeIndex(t *testing.T, backend Backend, name string) {
+	t.Helper()
+
+	addr := freeAddr(t)
+	setFlag(t, flagListen, addr)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serveIndex(ctx, backend, name)
+	}()
+
+	base := "http://" + addr
+	waitForHealthy(t, base)
+
+	var resp searchResponse
+	if status := getJSON(t, base+"/search?q=landfall&k=3", &resp); status != http.StatusOK {
+		t.Fatalf("GET /search: status %d", status)
+	}
+	if len(resp.Results) == 0 || resp.Results[0].Name != "Lotus Cobra" {
+		t.Errorf("searching for landfall: got %v, want Lotus Cobra first", resp.Results)
+	}
+	if len(resp.Results) > 3 {
+		t.Errorf("got %d results, want at most 3", len(resp.Results))
+	}
+
+	if status := getJSON(t, base+"/search?q=destroy+target+creature", &resp); status != http.StatusOK {
+		t.Fatalf("GET /search: status %d", status)
+	}
+	if len(resp.Results) == 0 || resp.Results[0].Name != "Murder" {
+		t.Errorf("searching for destroy target creature: got %v, want Murder first", resp.Results)
+	}
+
+	if status := getJSON(t, base+"/search?type=creature&identity=g&format=pauper", &resp); status != http.StatusOK {
+		t.Fatalf("GET /search: status %d", status)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Name != "Llanowar Elves" {
+		t.Errorf("filtering for green pauper creatures: got %v, want Llanowar Elves", resp.Results)
+	}
+
+	q := url.QueryEscape(`t:creature -c:u mv<=1 o:"add {G}"`)
+	if status := getJSON(t, base+"/search?q="+q, &resp); status != http.StatusOK {
+		t.Fatalf("GET /search: status %d", status)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Name != "Llanowar Elves" {
+		t.Errorf("searching with query syntax: got %v, want Llanowar Elves", resp.Results)
+	}
+
+	if status := getJSON(t, base+"/search?q=scrying&mode=vector", &resp); status != http.StatusOK {
+		t.Fatalf("GET /search: status %d", status)
+	}
+	if resp.Mode != VectorSearch || len(resp.Results) == 0 || resp.Results[0].Name != "Opt" {
+		t.Errorf("vector search for scrying: got %v (mode %q), want Opt first", resp.Results, resp.Mode)
+	}
+
+	for _, bad := range []string{
+		"/search",
+		"/search?q=draw&mode=fuzzy",
+		"/search?q=" + url.QueryEscape("mv<=lots"),
+		"/search?q=" + url.QueryEscape(`o:"draw`),
+		"/search?q=draw&k=0",
+		"/search?q=draw&k=lots",
+		"/search?q=draw&mv=lots",
+		"/search?q=draw&collapse=maybe",
+		"/search?q=mord&lang=xx",
+		"/search?q=mord&lang=de&mode=vector",
+	} {
+		if status := getJSON(t, base+bad, &map[string]any{}); status != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want %d", bad, status, http.StatusBadRequest)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("serving index: %v", err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Error("server did not shut down")
+	}
+}
+
+func waitForHealthy(t *testing.T, base string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(base + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server at %s never became healthy", base)
+}
+
+func getJSON(t *testing.T, url string, v any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: decoding response: %v", url, err)
+	}
+	return resp.StatusCode
+}
+
+func TestParseFakeRankBy(t *testing.T) {
+	for i, expr := range []string{
+		`["Sum", [["Product", 2, ["name", "BM25", "q"]], ["Product", 1, ["text", "BM25", "q"]]]]`,
+		`["Sum", [["Product", [2, ["name", "BM25", "q"]]], ["text", "BM25", "q"]]]`,
+		`["sum", [["product", [["name", "BM25", "q"], 2]], ["text", "BM25", "q"]]]`,
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var parsed any
+			if err := json.Unmarshal([]byte(expr), &parsed); err != nil {
+				t.Fatal(err)
+			}
+			var query QueryRequest
+			if err := parseFakeRankBy(parsed, 1, &query); err != nil {
+				t.Fatalf("parsing %s: %v", expr, err)
+			}
+			want := []FieldWeight{{"name", 2}, {"text", 1}}
+			if query.Text != "q" || fmt.Sprint(query.Fields) != fmt.Sprint(want) {
+				t.Errorf("parsed %s as %q %v, want %q %v", expr, query.Text, query.Fields, "q", want)
+			}
+		})
+	}
+}
+
+func TestWriteDeletesAfterUpserts(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			backend, _ := newTestBackend(t, kind)
+			namespace := "mtg_deletes"
+			if err := backend.Write(t.Context(), namespace, WriteRequest{Upserts: []Row{{"id": "1"}, {"id": "2"}}}); err != nil {
+				t.Fatal(err)
+			}
+			// Row 2 is upserted and deleted in one write, so it's gone.
+			if err := backend.Write(t.Context(), namespace, WriteRequest{
+				Upserts: []Row{{"id": "2"}, {"id": "3"}},
+				Deletes: []string{"1", "2"},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			rows, err := backend.Query(t.Context(), namespace, QueryRequest{TopK: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, row := range rows {
+				ids = append(ids, fmt.Sprint(row["id"]))
+			}
+			if fmt.Sprint(ids) != "[3]" {
+				t.Errorf("got rows %v, want only 3", ids)
+			}
+		})
+	}
+}
+
+func TestRefreshIndex(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			backend, _ := newTestBackend(t, kind)
+			setFlag(t, flagSet, string(Standard))
+			fixture, _ := loadFixture(t)
+			t.Chdir(t.TempDir())
+
+			// Build from a local copy of the fixture, which we then edit.
+			source := filepath.Join(t.TempDir(), "StandardAtomic.json")
+			writeSet := func(set *AtomicSet) {
+				data, err := json.Marshal(set)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(source, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writeSet(fixture)
+			setFlag(t, flagSource, source)
+
+			ctx := t.Context()
+			if err := buildIndex(ctx, backend, "cards"); err != nil {
+				t.Fatalf("building index: %v", err)
+			}
+			before, err := LoadIndex("cards")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Refreshing an unchanged set is a no-op.
+			if err := refreshIndex(ctx, backend, "cards"); err != nil {
+				t.Fatalf("refreshing unchanged index: %v", err)
+			}
+			if unchanged, _ := LoadIndex("cards"); unchanged.UpdatedAt != nil {
+				t.Errorf("unchanged index was marked as updated at %v", unchanged.UpdatedAt)
+			}
+
+			// Errata one card, remove another, drop a face from a third and add a new card.
+			text := "Scry 2.\nDraw a card."
+			fixture.Data["Opt"][0].Text = &text
+			delete(fixture.Data, "Murder")
+			fixture.Data["Fire // Ice"] = fixture.Data["Fire // Ice"][:1]
+			newText := "Destroy target artifact."
+			fixture.Data["Shatter"] = []AtomicCard{{
+				Name:       "Shatter",
+				Text:       &newText,
+				Type:       "Instant",
+				Types:      []string{"Instant"},
+				Layout:     "normal",
+				ManaValue:  2,
+				Colors:     []string{"R"},
+				Legalities: Legalities{},
+			}}
+			fixture.Meta.Version = "5.2.2+20261015"
+			writeSet(fixture)
+
+			if err := refreshIndex(ctx, backend, "cards"); err != nil {
+				t.Fatalf("refreshing changed index: %v", err)
+			}
+			after, err := LoadIndex("cards")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Namespace != before.Namespace {
+				t.Errorf("refresh changed namespace from %q to %q", before.Namespace, after.Namespace)
+			}
+			if after.Version != "5.2.2+20261015" || after.UpdatedAt == nil {
+				t.Errorf("refresh recorded version %q at %v", after.Version, after.UpdatedAt)
+			}
+			if after.Checksum == before.Checksum {
+				t.Error("refresh did not update the checksum")
+			}
+			if got, want := after.Cards["Opt"].IDs, before.Cards["Opt"].IDs; fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("changed card was given new ids %v, want %v", got, want)
+			}
+			if _, ok := after.Cards["Murder"]; ok {
+				t.Error("removed card is still recorded in the index")
+			}
+
+			var numFaces int64
+			for _, faces := range fixture.Data {
+				numFaces += int64(len(faces))
+			}
+			meta, err := backend.Metadata(ctx, after.Namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.ApproxRowCount != numFaces {
+				t.Errorf("namespace has %d rows after refresh, want %d", meta.ApproxRowCount, numFaces)
+			}
+
+			results, err := after.Search(ctx, backend, SearchRequest{Query: "destroy target", TopK: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, result := range results {
+				if result.Name == "Murder" {
+					t.Error("removed card is still searchable")
+				}
+			}
+			if len(results) == 0 || results[0].Name != "Shatter" {
+				t.Errorf("searching for destroy target: got %v, want Shatter first", results)
+			}
+		})
+	}
+}
+
+// interruptingBackend simulates the build being interrupted (e.g. by Ctrl-C) mid-upload: the first
+// write goes through, then the context is cancelled.
+type interruptingBackend struct {
+	Backend
+	cancel    context.CancelFunc
+	namespace string
+}
+
+func (b *interruptingBackend) Write(ctx context.Context, namespace string, req WriteRequest) error {
+	b.namespace = namespace
+	if err := b.Backend.Write(ctx, namespace, req); err != nil {
+		return err
+	}
+	b.cancel()
+	return context.Canceled
+}
+
+func TestBuildIndexCleansUp(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			serveFixtures(t)
+			backend, _ := newTestBackend(t, kind)
+			setFlag(t, flagSet, string(Standard))
+			t.Chdir(t.TempDir())
+
+			assertCleanedUp := func(namespace string) {
+				t.Helper()
+				for _, fp := range []string{indexFilepath("cards"), stagingFilepath("cards")} {
+					if _, err := os.Stat(fp); !os.IsNotExist(err) {
+						t.Errorf("%q exists after cleaning up (err: %v)", fp, err)
+					}
+				}
+				if _, err := backend.Metadata(t.Context(), namespace); !errors.Is(err, ErrNamespaceNotFound) {
+					t.Errorf("namespace %q after cleaning up: got %v, want ErrNamespaceNotFound", namespace, err)
+				}
+			}
+
+			// An interrupted build deletes what it wrote.
+			ctx, cancel := context.WithCancel(t.Context())
+			interrupting := &interruptingBackend{Backend: backend, cancel: cancel}
+			if err := buildIndex(ctx, interrupting, "cards"); err == nil {
+				t.Fatal("interrupted build succeeded")
+			}
+			if interrupting.namespace == "" {
+				t.Fatal("interrupted build never wrote to a namespace")
+			}
+			assertCleanedUp(interrupting.namespace)
+
+			// A build which died without cleaning up blocks new builds until deleted.
+			const namespace = "mtg_cards_20261016-000000_deadbeef"
+			if err := backend.Write(t.Context(), namespace, WriteRequest{Upserts: []Row{{"id": "1"}}}); err != nil {
+				t.Fatal(err)
+			}
+			staged := &Index{Name: "cards", Namespace: namespace, State: IndexBuilding}
+			if err := staged.writeFile(stagingFilepath("cards")); err != nil {
+				t.Fatal(err)
+			}
+			if err := buildIndex(t.Context(), backend, "cards"); err == nil || !strings.Contains(err.Error(), "already being built") {
+				t.Fatalf("building over an interrupted build: got error %v, want it to be blocked", err)
+			}
+			if index, _ := LoadIndex("cards"); index != nil {
+				t.Fatal("built over an interrupted build without cleaning it up")
+			}
+			if err := deleteIndex(t.Context(), backend, "cards"); err != nil {
+				t.Fatalf("deleting interrupted build: %v", err)
+			}
+			assertCleanedUp(namespace)
+
+			// Including one which died before recording its namespace.
+			if err := os.WriteFile(stagingFilepath("cards"), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := deleteIndex(t.Context(), backend, "cards"); err != nil {
+				t.Fatalf("deleting interrupted build with an empty staging file: %v", err)
+			}
+
+			if err := buildIndex(t.Context(), backend, "cards"); err != nil {
+				t.Fatalf("building after cleaning up: %v", err)
+			}
+			index, err := LoadIndex("cards")
+			if err != nil || index == nil || index.State != IndexReady {
+				t.Fatalf("loading built index: %v (index: %+v)", err, index)
+			}
+			if _, err := os.Stat(stagingFilepath("cards")); !os.IsNotExist(err) {
+				t.Errorf("staging file exists after a successful build (err: %v)", err)
+			}
+		})
+	}
+}
+
+func TestListAndDescribeIndexes(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			serveFixtures(t)
+			backend, _ := newTestBackend(t, kind)
+			setFlag(t, flagSet, string(Standard))
+			t.Chdir(t.TempDir())
+
+			ctx := t.Context()
+			var out strings.Builder
+			if err := listIndexes(ctx, backend, &out); err != nil {
+				t.Fatalf("listing no indexes: %v", err)
+			}
+			if out.Len() != 0 {
+				t.Errorf("listing no indexes wrote %q", out.String())
+			}
+
+			for _, name := range []string{"modern", "cards"} {
+				if err := buildIndex(ctx, backend, name); err != nil {
+					t.Fatalf("building index %q: %v", name, err)
+				}
+			}
+			modern, err := LoadIndex("modern")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := backend.DeleteAll(ctx, modern.Namespace); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := listIndexes(ctx, backend, &out); err != nil {
+				t.Fatalf("listing indexes: %v", err)
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") {
+				t.Fatalf("got listing:\n%s\nwant a header and 2 indexes", out.String())
+			}
+			if fields := strings.Fields(lines[1]); fields[0] != "cards" || fields[5] != "10" {
+				t.Errorf("got listing line %q, want cards with 10 rows", lines[1])
+			}
+			if fields := strings.Fields(lines[2]); fields[0] != "modern" || fields[5] != "missing" {
+				t.Errorf("got listing line %q, want modern with a missing namespace", lines[2])
+			}
+
+			out.Reset()
+			if err := describeIndex(ctx, backend, "cards", &out); err != nil {
+				t.Fatalf("describing index: %v", err)
+			}
+			for _, want := range []string{"name:", "cards", "set:", "standard", "namespace rows:", "10", "embedder:", "hash"} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("description is missing %q:\n%s", want, out.String())
+				}
+			}
+			if err := describeIndex(ctx, backend, "missing", &out); err == nil {
+				t.Error("describing a missing index succeeded")
+			}
+		})
+	}
+}
+
+func TestIndexDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	setFlag(t, flagIndexDir, "")
+	if got, want := indexDir(), filepath.Join(home, ".local", "share", "puffingmtg", "indexes"); got != want {
+		t.Errorf("without XDG_DATA_HOME: got %q, want %q", got, want)
+	}
+
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	if got, want := indexDir(), filepath.Join(data, "puffingmtg", "indexes"); got != want {
+		t.Errorf("with XDG_DATA_HOME: got %q, want %q", got, want)
+	}
+	setFlag(t, flagMemoryDir, "")
+	if got, want := memoryDir(), filepath.Join(data, "puffingmtg", "memory"); got != want {
+		t.Errorf("memory dir with XDG_DATA_HOME: got %q, want %q", got, want)
+	}
+
+	setFlag(t, flagIndexDir, "elsewhere")
+	if got, want := indexFilepath("cards"), filepath.Join("elsewhere", "cards.json"); got != want {
+		t.Errorf("with -index-dir: got %q, want %q", got, want)
+	}
+
+	// Index files left in the current directory aren't silently ignored.
+	t.Chdir(t.TempDir())
+	legacy := &Index{Name: "cards", Namespace: "mtg_cards_20250101-000000_deadbeef"}
+	if err := legacy.writeFile("cards.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex("cards"); err == nil || !strings.Contains(err.Error(), "moved") {
+		t.Errorf("loading an index from the current directory: got %v, want an error asking to move it", err)
+	}
+	// gc doesn't know about their namespaces, so it refuses to run until they're moved.
+	if _, err := findOrphanedNamespaces(t.Context(), newMemoryBackend("")); err == nil || !strings.Contains(err.Error(), "cards.json") {
+		t.Errorf("finding orphans with an index in the current directory: got %v, want an error naming it", err)
+	}
+	setFlag(t, flagIndexDir, ".")
+	if index, err := LoadIndex("cards"); err != nil || index == nil {
+		t.Errorf("loading with -index-dir .: got %v, %v", index, err)
+	}
+}
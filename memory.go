@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// BM25 parameters used by the memory backend. These are the conventional defaults, and match
+// what turbopuffer uses.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// memoryBackend is a Backend which keeps namespaces in process memory, ranking rows with BM25
+// over the full-text attributes of the schema. It needs no network access, so it's suitable for
+// offline use and tests.
+//
+// If dir is set, each namespace is also persisted to disk, so that an index built by one
+// invocation can be served by the next: <dir>/<namespace>.json holds a snapshot of it, and every
+// write since is appended to <dir>/<namespace>.log, see memoryWrite. Once the log outgrows the
+// snapshot, it's compacted into a new snapshot, so building a namespace batch by batch costs I/O
+// linear in its size rather than rewriting it all for every batch.
+type memoryBackend struct {
+	dir string
+
+	mu         sync.Mutex
+	namespaces map[string]*memoryNamespace
+}
+
+// memoryNamespace is a namespace held by the memory backend. The exported fields are what gets
+// persisted to disk.
+type memoryNamespace struct {
+	CreatedAt time.Time      `json:"created_at"`
+	FullText  []string       `json:"full_text"`
+	Rows      map[string]Row `json:"rows"`
+
+	// fields holds the inverted index for each full-text attribute. It's built lazily on the
+	// first query after a write.
+	fields map[string]*memoryField
+
+	// snapshotSize and logSize are the sizes in bytes of the namespace's snapshot and log files.
+	snapshotSize, logSize int64
+}
+
+// memoryWrite is a write to a namespace, as appended to its log.
+type memoryWrite struct {
+	FullText []string `json:"full_text,omitempty"`
+	Upserts  []Row    `json:"upserts,omitempty"`
+	Deletes  []string `json:"deletes,omitempty"`
+}
+
+// apply applies a write to the namespace.
+func (ns *memoryNamespace) apply(w memoryWrite) {
+	for _, attr := range w.FullText {
+		if !slices.Contains(ns.FullText, attr) {
+			ns.FullText = append(ns.FullText, attr)
+		}
+	}
+	for _, row := range w.Upserts {
+		ns.Rows[row["id"].(string)] = row
+	}
+	for _, id := range w.Deletes {
+		delete(ns.Rows, id)
+	}
+	ns.fields = nil
+}
+
+// memoryField is the inverted index for a single full-text attribute.
+type memoryField struct {
+	postings map[string]map[string]int // term -> row id -> term frequency
+	lengths  map[string]int            // row id -> number of terms
+	avgLen   float64
+}
+
+func newMemoryBackend(dir string) *memoryBackend {
+	return &memoryBackend{
+		dir:        dir,
+		namespaces: make(map[string]*memoryNamespace),
+	}
+}
+
+func (b *memoryBackend) Write(_ context.Context, namespace string, req WriteRequest) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ns, err := b.load(namespace)
+	if errors.Is(err, ErrNamespaceNotFound) {
+		ns = &memoryNamespace{
+			CreatedAt: time.Now().UTC(),
+			Rows:      make(map[string]Row),
+		}
+		b.namespaces[namespace] = ns
+	} else if err != nil {
+		return err
+	}
+
+	w := memoryWrite{Deletes: req.Deletes}
+	for attr, config := range req.Schema {
+		if config.FullTextSearch != nil && !slices.Contains(ns.FullText, attr) {
+			w.FullText = append(w.FullText, attr)
+		}
+	}
+	slices.Sort(w.FullText)
+	for _, row := range req.Upserts {
+		// Round-trip each row through JSON so that values are stored (and later returned) in the
+		// same shape as they would be coming back from turbopuffer.
+		normalized, err := normalizeRow(row)
+		if err != nil {
+			return err
+		}
+		id, ok := normalized["id"].(string)
+		if !ok || id == "" {
+			return fmt.Errorf("row is missing a string id: %v", row["id"])
+		}
+		w.Upserts = append(w.Upserts, normalized)
+	}
+	ns.apply(w)
+
+	return b.append(namespace, ns, w)
+}
+
+func (b *memoryBackend) Query(_ context.Context, namespace string, req QueryRequest) ([]Row, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ns, err := b.load(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if ns.fields == nil {
+		ns.buildFields()
+	}
+
+	// Rows are ranked by descending score. Vector queries rank by ascending distance, so their
+	// scores are negated distances.
+	scores := make(map[string]float64)
+	switch {
+	case req.Vector != nil:
+		for id, row := range ns.Rows {
+			if dist, ok := cosineDistance(req.Vector, row["vector"]); ok {
+				scores[id] = -dist
+			}
+		}
+	case len(req.Fields) == 0:
+		for id := range ns.Rows {
+			scores[id] = 0
+		}
+	default:
+		terms := tokenize(req.Text)
+		for _, fw := range req.Fields {
+			field, ok := ns.fields[fw.Attribute]
+			if !ok {
+				return nil, fmt.Errorf("attribute %q is not full-text searchable", fw.Attribute)
+			}
+			for id, score := range field.bm25(terms, len(ns.Rows)) {
+				scores[id] += fw.Weight * score
+			}
+		}
+		for id, score := range scores {
+			if score <= 0 {
+				delete(scores, id)
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		if req.Filters != nil {
+			ok, err := matchesFilter(ns.Rows[id], *req.Filters)
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		if scores[a] != scores[b] {
+			if scores[a] > scores[b] {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	if req.TopK > 0 && len(ids) > req.TopK {
+		ids = ids[:req.TopK]
+	}
+
+	results := make([]Row, 0, len(ids))
+	for _, id := range ids {
+		dist := scores[id]
+		if req.Vector != nil {
+			dist = -dist
+		}
+		row := Row{"id": id, "$dist": dist}
+		for _, attr := range req.IncludeAttributes {
+			if v, ok := ns.Rows[id][attr]; ok {
+				row[attr] = v
+			}
+		}
+		results = append(results, row)
+	}
+	return results, nil
+}
+
+func (b *memoryBackend) Metadata(_ context.Context, namespace string) (*NamespaceMetadata, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ns, err := b.load(namespace)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, row := range ns.Rows {
+		encoded, err := json.Marshal(row)
+		if err != nil {
+			return nil, fmt.Errorf("encoding row %v: %w", row["id"], err)
+		}
+		size += int64(len(encoded))
+	}
+	return &NamespaceMetadata{
+		CreatedAt:          ns.CreatedAt,
+		ApproxRowCount:     int64(len(ns.Rows)),
+		ApproxLogicalBytes: size,
+	}, nil
+}
+
+func (b *memoryBackend) DeleteAll(_ context.Context, namespace string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := b.load(namespace); err != nil {
+		return err
+	}
+	delete(b.namespaces, namespace)
+	if b.dir == "" {
+		return nil
+	}
+	fp := b.namespaceFilepath(namespace)
+	if err := os.Remove(fp); err != nil {
+		return fmt.Errorf("deleting namespace file %q: %w", fp, err)
+	}
+	if err := os.Remove(b.logFilepath(namespace)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("deleting namespace log: %w", err)
+	}
+	return nil
+}
+
+func (b *memoryBackend) Namespaces(_ context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var names []string
+	for name := range b.namespaces {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	if b.dir != "" {
+		entries, err := os.ReadDir(b.dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("listing namespace files in %q: %w", b.dir, err)
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), ".json")
+			if ok && !entry.IsDir() && strings.HasPrefix(name, prefix) && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// load returns the namespace with the given name, reading its snapshot and replaying its log from
+// disk if it isn't already in memory. Callers must hold b.mu.
+func (b *memoryBackend) load(namespace string) (*memoryNamespace, error) {
+	if ns, ok := b.namespaces[namespace]; ok {
+		return ns, nil
+	}
+	if b.dir == "" {
+		return nil, fmt.Errorf("%w: %q", ErrNamespaceNotFound, namespace)
+	}
+
+	fp := b.namespaceFilepath(namespace)
+	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %q", ErrNamespaceNotFound, namespace)
+	} else if err != nil {
+		return nil, fmt.Errorf("opening namespace file %q: %w", fp, err)
+	}
+	defer f.Close()
+
+	var ns memoryNamespace
+	dec := json.NewDecoder(f)
+	if err := dec.Decode(&ns); err != nil {
+		return nil, fmt.Errorf("decoding namespace file %q: %w", fp, err)
+	}
+	if ns.Rows == nil {
+		ns.Rows = make(map[string]Row)
+	}
+	ns.snapshotSize = dec.InputOffset()
+	if err := ns.replay(b.logFilepath(namespace)); err != nil {
+		return nil, err
+	}
+	b.namespaces[namespace] = &ns
+	return &ns, nil
+}
+
+// replay applies the writes in the namespace's log, if it has one. A last write which was cut off
+// (e.g. by a crash) before its newline was never acknowledged, so it's ignored.
+func (ns *memoryNamespace) replay(fp string) error {
+	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("opening namespace log %q: %w", fp, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading namespace log %q: %w", fp, err)
+		}
+		var w memoryWrite
+		if err := json.Unmarshal(line, &w); err != nil {
+			return fmt.Errorf("decoding namespace log %q: %w", fp, err)
+		}
+		ns.apply(w)
+		ns.logSize += int64(len(line))
+	}
+}
+
+// append persists a write to the namespace, if the backend has a directory, by appending it to
+// the namespace's log. New namespaces, and those whose log has outgrown their snapshot, are
+// saved as a new snapshot instead. Callers must hold b.mu.
+func (b *memoryBackend) append(namespace string, ns *memoryNamespace, w memoryWrite) error {
+	if b.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("encoding write to namespace %q: %w", namespace, err)
+	}
+	data = append(data, '\n')
+	if ns.snapshotSize == 0 || ns.logSize+int64(len(data)) > ns.snapshotSize {
+		return b.save(namespace, ns)
+	}
+
+	fp := b.logFilepath(namespace)
+	f, err := os.OpenFile(fp, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening namespace log %q: %w", fp, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("writing namespace log %q: %w", fp, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing namespace log %q: %w", fp, err)
+	}
+	ns.logSize += int64(len(data))
+	return nil
+}
+
+// save persists a snapshot of a namespace to disk, if the backend has a directory, replacing its
+// log. Callers must hold b.mu.
+func (b *memoryBackend) save(namespace string, ns *memoryNamespace) error {
+	if b.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(b.dir, 0o755); err != nil {
+		return fmt.Errorf("creating namespace directory %q: %w", b.dir, err)
+	}
+	fp := b.namespaceFilepath(namespace)
+	data, err := json.Marshal(ns)
+	if err != nil {
+		return fmt.Errorf("encoding namespace %q: %w", namespace, err)
+	}
+	// Write to a temporary file first, so a crash mid-write never leaves a truncated namespace.
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("writing namespace file %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, fp); err != nil {
+		return fmt.Errorf("renaming namespace file %q: %w", tmp, err)
+	}
+	// The snapshot holds every write in the log, so a crash before the log is removed only
+	// replays writes which are already applied.
+	if err := os.Remove(b.logFilepath(namespace)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("deleting namespace log: %w", err)
+	}
+	ns.snapshotSize, ns.logSize = int64(len(data)), 0
+	return nil
+}
+
+func (b *memoryBackend) namespaceFilepath(namespace string) string {
+	return filepath.Join(b.dir, namespace+".json")
+}
+
+func (b *memoryBackend) logFilepath(namespace string) string {
+	return filepath.Join(b.dir, namespace+".log")
+}
+
+// buildFields (re)builds the inverted index for every full-text attribute in the namespace.
+func (ns *memoryNamespace) buildFields() {
+	ns.fields = make(map[string]*memoryField, len(ns.FullText))
+	for _, attr := range ns.FullText {
+		field := &memoryField{
+			postings: make(map[string]map[string]int),
+			lengths:  make(map[string]int),
+		}
+		var total int
+		for id, row := range ns.Rows {
+			terms := tokenize(attributeText(row[attr]))
+			for _, term := range terms {
+				if field.postings[term] == nil {
+					field.postings[term] = make(map[string]int)
+				}
+				field.postings[term][id]++
+			}
+			field.lengths[id] = len(terms)
+			total += len(terms)
+		}
+		if len(ns.Rows) > 0 {
+			field.avgLen = float64(total) / float64(len(ns.Rows))
+		}
+		ns.fields[attr] = field
+	}
+}
+
+// bm25 scores every row containing at least one of the terms, out of numRows total rows.
+func (f *memoryField) bm25(terms []string, numRows int) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, term := range terms {
+		postings := f.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (float64(numRows)-df+0.5)/(df+0.5))
+		for id, tf := range postings {
+			norm := 1 - bm25B
+			if f.avgLen > 0 {
+				norm += bm25B * float64(f.lengths[id]) / f.avgLen
+			}
+			freq := float64(tf)
+			scores[id] += idf * freq * (bm25K1 + 1) / (freq + bm25K1*norm)
+		}
+	}
+	return scores
+}
+
+// attributeText flattens a (JSON-decoded) attribute value into a single string to be tokenized.
+func attributeText(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, elem := range v {
+			parts = append(parts, attributeText(elem))
+		}
+		return strings.Join(parts, " ")
+	default:
+		return ""
+	}
+}
+
+// tokenize splits text into lowercase terms on anything that isn't a letter or a digit. Chinese
+// and Japanese characters (including kana) are terms of their own, as those languages don't
+// separate words with spaces. turbopuffer would split runs of them differently, so they're only
+// searched once segmented, see Language.segment, which both backends split the same way. Unlike
+// turbopuffer, no stemming is applied, so matches are exact at the word level.
+func tokenize(text string) []string {
+	var (
+		lower = strings.ToLower(text)
+		terms []string
+		start = -1
+	)
+	for i, r := range lower {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+			if start >= 0 {
+				terms = append(terms, lower[start:i])
+				start = -1
+			}
+			terms = append(terms, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+		case start >= 0:
+			terms = append(terms, lower[start:i])
+			start = -1
+		}
+	}
+	if start >= 0 {
+		terms = append(terms, lower[start:])
+	}
+	return terms
+}
+
+// matchesFilter reports whether a row satisfies a filter. Filter values are normalized the same
+// way rows are before comparing, so that e.g. ints compare equal to the floats rows decode to.
+func matchesFilter(row Row, f Filter) (bool, error) {
+	switch f.Op {
+	case OpAnd:
+		for _, sub := range f.Filters {
+			if ok, err := matchesFilter(row, sub); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case OpOr:
+		for _, sub := range f.Filters {
+			if ok, err := matchesFilter(row, sub); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case OpNot:
+		if len(f.Filters) != 1 {
+			return false, fmt.Errorf("Not filter expects exactly one filter, got %d", len(f.Filters))
+		}
+		ok, err := matchesFilter(row, f.Filters[0])
+		return !ok, err
+	}
+
+	value, err := normalizeValue(f.Value)
+	if err != nil {
+		return false, fmt.Errorf("normalizing filter value %v: %w", f.Value, err)
+	}
+	actual := row[f.Attribute]
+	switch f.Op {
+	case OpEq:
+		return reflect.DeepEqual(actual, value), nil
+	case OpIn:
+		values, ok := value.([]any)
+		if !ok {
+			return false, fmt.Errorf("In filter on %q expects an array, got %T", f.Attribute, f.Value)
+		}
+		return slices.ContainsFunc(values, func(v any) bool {
+			return reflect.DeepEqual(actual, v)
+		}), nil
+	case OpLt, OpLte, OpGt, OpGte:
+		cmp, ok := compareValues(actual, value)
+		if !ok {
+			return false, nil
+		}
+		switch f.Op {
+		case OpLt:
+			return cmp < 0, nil
+		case OpLte:
+			return cmp <= 0, nil
+		case OpGt:
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case OpContains:
+		elems, _ := actual.([]any)
+		return slices.ContainsFunc(elems, func(v any) bool {
+			return reflect.DeepEqual(v, value)
+		}), nil
+	case OpContainsAny:
+		values, ok := value.([]any)
+		if !ok {
+			return false, fmt.Errorf(
+				"ContainsAny filter on %q expects an array, got %T", f.Attribute, f.Value,
+			)
+		}
+		elems, _ := actual.([]any)
+		return slices.ContainsFunc(elems, func(elem any) bool {
+			return slices.ContainsFunc(values, func(v any) bool {
+				return reflect.DeepEqual(elem, v)
+			})
+		}), nil
+	case OpContainsAllTokens:
+		text, ok := value.(string)
+		if !ok {
+			return false, fmt.Errorf(
+				"ContainsAllTokens filter on %q expects a string, got %T", f.Attribute, f.Value,
+			)
+		}
+		tokens := tokenize(attributeText(actual))
+		for _, token := range tokenize(text) {
+			if !slices.Contains(tokens, token) {
+				return false, nil
+			}
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported filter operator %q", f.Op)
+	}
+}
+
+// compareValues compares two numbers or two strings, reporting false if they aren't comparable
+// (e.g. either is null).
+func compareValues(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+func normalizeValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func normalizeRow(row Row) (Row, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, fmt.Errorf("encoding row %v: %w", row["id"], err)
+	}
+	var normalized Row
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, fmt.Errorf("decoding row %v: %w", row["id"], err)
+	}
+	return normalized, nil
+}
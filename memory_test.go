@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryPersistence(t *testing.T) {
+	dir := t.TempDir()
+	const namespace = "mtg_cards"
+	backend := newMemoryBackend(dir)
+	write := func(ids ...int) {
+		t.Helper()
+		var req WriteRequest
+		for _, id := range ids {
+			req.Upserts = append(req.Upserts, Row{"id": fmt.Sprint(id), "name": "Llanowar Elves"})
+		}
+		if err := backend.Write(t.Context(), namespace, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows := func() int64 {
+		t.Helper()
+		meta, err := newMemoryBackend(dir).Metadata(t.Context(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta.ApproxRowCount
+	}
+	logFilepath := filepath.Join(dir, namespace+".log")
+
+	// The first write saves a snapshot, and small writes after it are appended to the log.
+	write(1, 2, 3, 4, 5, 6, 7, 8)
+	snapshot, err := os.ReadFile(filepath.Join(dir, namespace+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(9)
+	write(10)
+	if err := backend.Write(t.Context(), namespace, WriteRequest{Deletes: []string{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(dir, namespace+".json")); string(again) != string(snapshot) {
+		t.Error("small writes rewrote the snapshot")
+	}
+	if got := rows(); got != 9 {
+		t.Errorf("got %d rows after replaying the log, want 9", got)
+	}
+
+	// A write cut off before its newline is ignored.
+	f, err := os.OpenFile(logFilepath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"upserts":[{"id":"11"`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := rows(); got != 9 {
+		t.Errorf("got %d rows after replaying a cut off log, want 9", got)
+	}
+
+	// Once the log outgrows the snapshot, it's compacted into a new one.
+	backend = newMemoryBackend(dir)
+	write(11, 12, 13, 14, 15, 16, 17, 18, 19, 20)
+	if _, err := os.Stat(logFilepath); !os.IsNotExist(err) {
+		t.Errorf("log wasn't compacted: %v", err)
+	}
+	if got := rows(); got != 19 {
+		t.Errorf("got %d rows after compacting, want 19", got)
+	}
+
+	write(21)
+	if err := backend.DeleteAll(t.Context(), namespace); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("deleting the namespace left %d files", len(entries))
+	}
+}
@@ -1,11 +1,41 @@
 package main
 
-type AtomicSet struct {
-	Data map[string][]AtomicCard `json:"data"`
-	Meta struct {
-		Date    string `json:"date"`    // Example: "2025-08-30"
-		Version string `json:"version"` // Example: "5.2.2+20250830"
-	}
+import "slices"
+
+// Meta is the metadata of an mtgjson file, identifying the version of its data.
+type Meta struct {
+	Date    string `json:"date"`    // Example: "2025-08-30"
+	Version string `json:"version"` // Example: "5.2.2+20250830"
+}
+
+// SetData is a set, as published in a set file (e.g. MH3.json) or AllPrintings.json. Unlike atomic
+// files, sets hold every printing of every card in the set.
+type SetData struct {
+	Cards       []SetCard `json:"cards"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	ReleaseDate string    `json:"releaseDate"`
+	Type        string    `json:"type"`
+}
+
+// SetCard is a printing of a card face in a set. The fields it shares with AtomicCard describe the
+// card itself, the rest are specific to the printing.
+type SetCard struct {
+	AtomicCard
+	Artist              *string  `json:"artist,omitempty"`
+	BorderColor         string   `json:"borderColor"`
+	Finishes            []string `json:"finishes"`
+	FlavorText          *string  `json:"flavorText,omitempty"`
+	FrameEffects        []string `json:"frameEffects,omitempty"`
+	FrameVersion        string   `json:"frameVersion"`
+	IsPromo             *bool    `json:"isPromo,omitempty"`
+	Language            string   `json:"language"`
+	Number              string   `json:"number"`
+	OriginalReleaseDate *string  `json:"originalReleaseDate,omitempty"`
+	Rarity              string   `json:"rarity"`
+	SetCode             string   `json:"setCode"`
+	UUID                string   `json:"uuid"`
+	Variations          []string `json:"variations,omitempty"`
 }
 
 type AtomicCard struct {
@@ -113,6 +143,24 @@ type LeadershipSkills struct {
 	Oathbreaker bool `json:"oathbreaker"`
 }
 
+// Formats returns the names of the formats the card can lead a deck in.
+func (l *LeadershipSkills) Formats() []string {
+	if l == nil {
+		return nil
+	}
+	var formats []string
+	if l.Brawl {
+		formats = append(formats, "brawl")
+	}
+	if l.Commander {
+		formats = append(formats, "commander")
+	}
+	if l.Oathbreaker {
+		formats = append(formats, "oathbreaker")
+	}
+	return formats
+}
+
 type Legalities struct {
 	Alchemy         *string `json:"alchemy,omitempty"`
 	Brawl           *string `json:"brawl,omitempty"`
@@ -138,6 +186,55 @@ type Legalities struct {
 	Timeless        *string `json:"timeless,omitempty"`
 	Vintage         *string `json:"vintage,omitempty"`
 }
+
+// Formats returns the status of the card ("Legal", "Restricted", "Banned" or "Not Legal") in each
+// format it has a status for, keyed by the mtgjson format name.
+func (l Legalities) Formats() map[string]string {
+	formats := make(map[string]string)
+	for format, status := range map[string]*string{
+		"alchemy":         l.Alchemy,
+		"brawl":           l.Brawl,
+		"commander":       l.Commander,
+		"duel":            l.Duel,
+		"explorer":        l.Explorer,
+		"future":          l.Future,
+		"gladiator":       l.Gladiator,
+		"historic":        l.Historic,
+		"historicbrawl":   l.HistoricBrawl,
+		"legacy":          l.Legacy,
+		"modern":          l.Modern,
+		"oathbreaker":     l.Oathbreaker,
+		"oldschool":       l.Oldschool,
+		"pauper":          l.Pauper,
+		"paupercommander": l.PauperCommander,
+		"penny":           l.Penny,
+		"pioneer":         l.Pioneer,
+		"predh":           l.Predh,
+		"premodern":       l.Premodern,
+		"standard":        l.Standard,
+		"standardbrawl":   l.StandardBrawl,
+		"timeless":        l.Timeless,
+		"vintage":         l.Vintage,
+	} {
+		if status != nil {
+			formats[format] = *status
+		}
+	}
+	return formats
+}
+
+// WithStatus returns the sorted names of the formats in which the card has the given status.
+func (l Legalities) WithStatus(status string) []string {
+	var formats []string
+	for format, s := range l.Formats() {
+		if s == status {
+			formats = append(formats, format)
+		}
+	}
+	slices.Sort(formats)
+	return formats
+}
+
 type PurchaseUrls struct {
 	CardKingdom       *string `json:"cardKingdom,omitempty"`
 	CardKingdomEtched *string `json:"cardKingdomEtched,omitempty"`
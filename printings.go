@@ -0,0 +1,202 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/turbopuffer/turbopuffer-go"
+)
+
+// IndexMode is an enumeration of what the rows of an index represent.
+type IndexMode string
+
+// List of supported index modes.
+var (
+	// CardMode indexes have a row per face of each unique card, ignoring reprints. Index files
+	// written before modes were recorded have no mode, and are card indexes.
+	CardMode IndexMode = "cards"
+
+	// PrintingMode indexes have a row per face of each printing of a card, i.e. each time it was
+	// printed in a set. They're built from sets with printings, see Set.HasPrintings.
+	PrintingMode IndexMode = "printings"
+)
+
+func (m IndexMode) Valid() bool {
+	switch m {
+	case CardMode, PrintingMode:
+		return true
+	default:
+		return false
+	}
+}
+
+// collapseOversample is how many more rows than results are asked for when collapsing the
+// printings of a card into a single result, as a card's printings tend to match together.
+const collapseOversample = 16
+
+// printingSearchAttributes are the attributes included for each face in search results of
+// printing indexes, on top of searchAttributes.
+var printingSearchAttributes = []string{"printing_id", "set_code", "number", "rarity"}
+
+// printingFace is a face of a printing, along with the set it was printed in.
+type printingFace struct {
+	SetCard
+	set *SetData // not part of the face's JSON, so it doesn't affect checksums
+}
+
+// upsertPrintings is upsertSet for printing indexes, writing every printing of every card as
+// they're streamed. Printings are keyed by printingKey.
+func upsertPrintings(
+	ctx context.Context,
+	backend Backend,
+	namespace string,
+	printings entryStream[printingFace],
+	previous map[string]IndexedCard,
+	embedder Embedder,
+	hooks *uploadHooks,
+) (map[string]IndexedCard, error) {
+	return upsertEntries(
+		ctx,
+		backend,
+		namespace,
+		printings,
+		previous,
+		embedder,
+		hooks,
+		printingSchema(),
+		func(_ string, face printingFace) Row { return buildPrintingRow(face.SetCard, *face.set) },
+	)
+}
+
+// printingEntries returns a function which groups the cards of each set it's given into
+// printings, yielding each printing with its faces.
+func printingEntries(yield func(key string, faces []printingFace) error) func(SetData) error {
+	return func(data SetData) error {
+		var (
+			keys      []string
+			printings = make(map[string][]printingFace)
+		)
+		for _, card := range data.Cards {
+			key := printingKey(card)
+			if _, ok := printings[key]; !ok {
+				keys = append(keys, key)
+			}
+			printings[key] = append(printings[key], printingFace{SetCard: card, set: &data})
+		}
+		for _, key := range keys {
+			if err := yield(key, printings[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// printingKey identifies a printing of a card, shared by all of its faces, e.g.
+// "MH2:290:Fire // Ice".
+func printingKey(card SetCard) string {
+	return fmt.Sprintf("%s:%s:%s", card.SetCode, card.Number, card.Name)
+}
+
+// printingID returns the ID shared by all faces of a printing. Like rowID, it's stable across
+// builds and mtgjson releases.
+func printingID(card SetCard) string {
+	return uuid.NewSHA1(rowIDNamespace, []byte("printing:"+printingKey(card))).String()
+}
+
+// printingRowID returns the ID of the row for a face of a printing.
+func printingRowID(card SetCard) string {
+	var side string
+	if card.Side != nil {
+		side = *card.Side
+	}
+	key := fmt.Sprintf("printing:%s:%s", printingKey(card), side)
+	return uuid.NewSHA1(rowIDNamespace, []byte(key)).String()
+}
+
+// buildPrintingRow builds the row for a face of a printing: the row of the card, see buildRow,
+// plus the attributes of the printing. card_id is still the ID of the card, so the printings of a
+// card can be grouped back together.
+func buildPrintingRow(card SetCard, set SetData) Row {
+	// The name of a card in a set is the key of its entry in atomic files.
+	row := buildRow(printingRowID(card), card.Name, card.AtomicCard)
+	row["printing_id"] = printingID(card)
+	row["set_code"] = card.SetCode
+	row["set_name"] = set.Name
+	row["release_date"] = cmp.Or(card.OriginalReleaseDate, &set.ReleaseDate)
+	row["number"] = card.Number
+	row["rarity"] = card.Rarity
+	row["artist"] = card.Artist
+	row["flavor_text"] = card.FlavorText
+	row["border_color"] = card.BorderColor
+	row["frame_version"] = card.FrameVersion
+	row["frame_effects"] = card.FrameEffects
+	row["finishes"] = card.Finishes
+	row["language"] = card.Language
+	row["is_promo"] = card.IsPromo != nil && *card.IsPromo
+	row["mtgjson_uuid"] = card.UUID
+	row["scryfall_id"] = card.Identifiers.ScryfallId
+	return row
+}
+
+func printingSchema() Schema {
+	schema := turbopufferSchema()
+	for _, attr := range []string{
+		"set_code",
+		"set_name",
+		"release_date",
+		"number",
+		"rarity",
+		"artist",
+		"border_color",
+		"frame_version",
+		"language",
+		"mtgjson_uuid",
+		"scryfall_id",
+	} {
+		schema[attr] = turbopuffer.AttributeSchemaConfigParam{
+			Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		}
+	}
+	schema["printing_id"] = turbopuffer.AttributeSchemaConfigParam{
+		Type: turbopuffer.Opt(turbopuffer.AttributeType("uuid")),
+	}
+	schema["flavor_text"] = turbopuffer.AttributeSchemaConfigParam{
+		Type: turbopuffer.Opt(turbopuffer.AttributeType("string")),
+		FullTextSearch: &turbopuffer.FullTextSearchConfigParam{
+			Stemming:        turbopuffer.Bool(true),
+			RemoveStopwords: turbopuffer.Bool(false),
+		},
+	}
+	schema["frame_effects"] = turbopuffer.AttributeSchemaConfigParam{
+		Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+	}
+	schema["finishes"] = turbopuffer.AttributeSchemaConfigParam{
+		Type: turbopuffer.Opt(turbopuffer.AttributeType("[]string")),
+	}
+	schema["is_promo"] = turbopuffer.AttributeSchemaConfigParam{
+		Type: turbopuffer.Opt(turbopuffer.AttributeType("bool")),
+	}
+	return schema
+}
+
+// bestPrintings drops every row whose printing isn't the best ranked printing of its card, so
+// grouping the rows by card collapses each card to its best printing.
+func bestPrintings(rows []Row) []Row {
+	var (
+		kept []Row
+		best = make(map[string]string)
+	)
+	for _, row := range rows {
+		card, _ := row["card_id"].(string)
+		printing, _ := row["printing_id"].(string)
+		if p, ok := best[card]; ok && p != printing {
+			continue
+		}
+		best[card] = printing
+		kept = append(kept, row)
+	}
+	return kept
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPrintingIndex(t *testing.T) {
+	for _, kind := range []BackendKind{TurbopufferBackend, MemoryBackend} {
+		t.Run(string(kind), func(t *testing.T) {
+			serveFixtures(t)
+			backend, _ := newTestBackend(t, kind)
+			setFlag(t, flagSet, "tst")
+			setFlag(t, flagIndexMode, string(PrintingMode))
+			t.Chdir(t.TempDir())
+
+			ctx := t.Context()
+			if err := buildIndex(ctx, backend, "printings"); err != nil {
+				t.Fatalf("building index: %v", err)
+			}
+			index, err := LoadIndex("printings")
+			if err != nil || index == nil {
+				t.Fatalf("loading built index: %v (index: %v)", err, index)
+			}
+			if index.Mode != PrintingMode || index.Set != "TST" {
+				t.Errorf("index has mode %q and set %q, want printings of TST", index.Mode, index.Set)
+			}
+			wantKeys := []string{
+				"TST:101:Lightning Bolt",
+				"TST:1:Lightning Bolt",
+				"TST:2:Counterspell",
+				"TST:3:Wear // Tear",
+			}
+			var keys []string
+			for key := range index.Cards {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			if !slices.Equal(keys, wantKeys) {
+				t.Errorf("indexed printings %v, want %v", keys, wantKeys)
+			}
+
+			// Each printing of Lightning Bolt is a result, unless collapsed.
+			results, err := index.Search(ctx, backend, SearchRequest{Query: "lightning", Mode: KeywordSearch, TopK: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var numbers []string
+			for _, result := range results {
+				if result.Name != "Lightning Bolt" || result.PrintingID == "" || result.Faces[0]["set_code"] != "TST" {
+					t.Errorf("unexpected result %+v", result)
+					continue
+				}
+				numbers = append(numbers, result.Faces[0]["number"].(string))
+			}
+			slices.Sort(numbers)
+			if !slices.Equal(numbers, []string{"1", "101"}) {
+				t.Errorf("search for lightning found printings %v, want 1 and 101", numbers)
+			}
+			if len(results) == 2 && results[0].CardID != results[1].CardID {
+				t.Errorf("printings of Lightning Bolt have card IDs %q and %q, want the same", results[0].CardID, results[1].CardID)
+			}
+
+			results, err = index.Search(ctx, backend, SearchRequest{
+				Query:    "lightning",
+				Mode:     KeywordSearch,
+				TopK:     10,
+				Collapse: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 || results[0].Name != "Lightning Bolt" || len(results[0].Faces) != 1 {
+				t.Errorf("collapsed search for lightning: got %+v, want a single printing of Lightning Bolt", results)
+			}
+
+			// Both faces of a printing are fetched, even if only one matched.
+			results, err = index.Search(ctx, backend, SearchRequest{Query: "enchantment", Mode: KeywordSearch, TopK: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 || len(results[0].Faces) != 2 {
+				t.Fatalf("search for enchantment: got %+v, want both faces of Wear // Tear", results)
+			}
+			for _, face := range results[0].Faces {
+				if face["printing_id"] != results[0].PrintingID {
+					t.Errorf("face %v isn't from printing %q", face, results[0].PrintingID)
+				}
+			}
+
+			if changed, err := index.Refresh(ctx, backend, ""); err != nil || changed {
+				t.Errorf("refreshing unchanged index: changed = %v, err = %v", changed, err)
+			}
+
+			// Atomic sets have no printings to index.
+			setFlag(t, flagSet, string(Standard))
+			if err := buildIndex(ctx, backend, "standard"); err == nil {
+				t.Error("building a printing index of an atomic set succeeded")
+			}
+		})
+	}
+}
+
+func TestBuildPrintingRow(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "TST.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var set SetData
+	if _, _, err := streamPrintings(f, Set("TST"), func(data SetData) error {
+		set = data
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	row := buildPrintingRow(set.Cards[0], set)
+	for attr, want := range map[string]any{
+		"set_code":     "TST",
+		"set_name":     "Test Set",
+		"number":       "1",
+		"rarity":       "common",
+		"mtgjson_uuid": "20000000-0000-4000-8000-000000000001",
+		"is_promo":     false,
+	} {
+		if got := row[attr]; got != want {
+			t.Errorf("row[%q] = %v, want %v", attr, got, want)
+		}
+	}
+	if got := *row["release_date"].(*string); got != "2026-10-01" {
+		t.Errorf("release date = %q, want the release date of the set", got)
+	}
+	if got := *row["artist"].(*string); got != "Christopher Rush" {
+		t.Errorf("artist = %q, want Christopher Rush", got)
+	}
+	if row["card_id"] != cardID(set.Cards[0].Name) {
+		t.Error("printing row has a different card ID than the card")
+	}
+	if row["id"] == buildPrintingRow(set.Cards[4], set)["id"] {
+		t.Error("two printings of Lightning Bolt have the same row ID")
+	}
+}
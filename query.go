@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ParsedQuery is a search query written in Scryfall-style syntax, split into structured filters
+// and the remaining free text, which is ranked with BM25.
+type ParsedQuery struct {
+	Text    string
+	Filters SearchFilters
+}
+
+// ParseError describes a problem with a query, pointing at the offending token.
+type ParseError struct {
+	Query string
+	Pos   int // byte offset of Token in Query
+	Token string
+	Msg   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %q at column %d", e.Msg, e.Token, e.Pos+1)
+}
+
+// Pointer renders the query with a caret underneath the offending token, e.g.
+//
+//	t:creature mv<=three
+//	           ^^^^^^^^^
+func (e *ParseError) Pointer() string {
+	width := max(len([]rune(e.Token)), 1)
+	indent := len([]rune(e.Query[:e.Pos]))
+	return e.Query + "\n" + strings.Repeat(" ", indent) + strings.Repeat("^", width)
+}
+
+// queryToken is a single whitespace-separated term of a query, e.g. `-o:"draw a card"`.
+type queryToken struct {
+	pos     int
+	raw     string
+	negated bool
+	key     string // empty for free text
+	op      string
+	value   string
+}
+
+// queryTerm matches keyword terms such as "t:creature" or "mv<=3", once the leading "-" of a
+// negated term has been removed. Terms with an empty value are only keyword terms if their key is
+// one of queryKeywords, so words of card names such as "Circle of Protection: Red" are free text,
+// while typos such as "typ:creature" are still errors.
+var queryTerm = regexp.MustCompile(`^([a-zA-Z]+)(:|<=|>=|!=|=|<|>)(.*)$`)
+
+// queryKeywords are the keys of keyword terms, see queryToken.filters.
+var queryKeywords = []string{
+	"c", "color", "colors", "id", "identity", "ci",
+	"t", "type",
+	"o", "oracle",
+	"kw", "keyword",
+	"f", "format", "legal",
+	"mv", "cmc", "manavalue", "pow", "power", "tou", "toughness",
+	"is",
+}
+
+// colorNames maps the names of colors, as accepted in queries, to their abbreviations.
+var colorNames = map[string]string{
+	"white":     "W",
+	"blue":      "U",
+	"black":     "B",
+	"red":       "R",
+	"green":     "G",
+	"colorless": "C",
+}
+
+// ParseQuery parses a Scryfall-style query, e.g.
+//
+//	c:g t:creature mv<=3 o:"draw a card" f:modern -is:reserved landfall
+//
+// Supported keywords are c/color, id/identity, t/type, o/oracle, kw/keyword, f/format/legal,
+// mv/cmc/manavalue, pow/power, tou/toughness and is:reserved. Any keyword term may be negated with
+// a leading "-". Everything which isn't a keyword term is free text, including words starting
+// with "-" (e.g. -1/-1) and "or". Uppercase OR and parentheses aren't supported.
+func ParseQuery(query string) (ParsedQuery, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return ParsedQuery{}, err
+	}
+
+	var (
+		parsed ParsedQuery
+		text   []string
+	)
+	for _, tok := range tokens {
+		if tok.key == "" {
+			// Like Scryfall, only uppercase OR is an operator; "or" is just a word.
+			if tok.value == "OR" || strings.HasPrefix(tok.value, "(") {
+				return ParsedQuery{}, tok.errorf(query, "OR and parentheses are not supported")
+			}
+			text = append(text, tok.value)
+			continue
+		}
+
+		filters, err := tok.filters(query)
+		if err != nil {
+			return ParsedQuery{}, err
+		}
+		switch {
+		case tok.negated && filters.Reserved != nil:
+			// -is:reserved is simply is_reserved = false.
+			notReserved := !*filters.Reserved
+			filters = SearchFilters{Reserved: &notReserved}
+		case tok.negated:
+			filters = SearchFilters{Exclude: []SearchFilters{filters}}
+		}
+		if parsed.Filters, err = parsed.Filters.And(filters); err != nil {
+			return ParsedQuery{}, tok.errorf(query, "%v", err)
+		}
+	}
+	parsed.Text = strings.Join(text, " ")
+	return parsed, nil
+}
+
+// tokenizeQuery splits a query into terms on whitespace, keeping quoted values together.
+func tokenizeQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(query); {
+		if unicode.IsSpace(rune(query[i])) {
+			i++
+			continue
+		}
+
+		start := i
+		inQuote := false
+		for i < len(query) && (inQuote || !unicode.IsSpace(rune(query[i]))) {
+			if query[i] == '"' {
+				inQuote = !inQuote
+			}
+			i++
+		}
+		tok := queryToken{pos: start, raw: query[start:i]}
+		if inQuote {
+			return nil, tok.errorf(query, "unterminated quote")
+		}
+
+		// Only keyword terms can be negated. Otherwise, a leading "-" is part of the word, as in
+		// "-1/-1".
+		term, negated := strings.CutPrefix(tok.raw, "-")
+		m := queryTerm.FindStringSubmatch(term)
+		if m != nil && (m[3] != "" || slices.Contains(queryKeywords, strings.ToLower(m[1]))) {
+			tok.negated = negated
+			tok.key = strings.ToLower(m[1])
+			tok.op = m[2]
+			tok.value = unquote(m[3])
+			if tok.value == "" {
+				return nil, tok.errorf(query, "missing value")
+			}
+		} else {
+			tok.value = unquote(tok.raw)
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func (tok queryToken) errorf(query, format string, args ...any) *ParseError {
+	return &ParseError{
+		Query: query,
+		Pos:   tok.pos,
+		Token: tok.raw,
+		Msg:   fmt.Sprintf(format, args...),
+	}
+}
+
+// filters returns the filters described by a keyword term.
+func (tok queryToken) filters(query string) (SearchFilters, error) {
+	var filters SearchFilters
+	switch tok.key {
+	case "c", "color", "colors", "id", "identity", "ci":
+		colors, err := parseColorValue(tok.value)
+		if err != nil {
+			return SearchFilters{}, tok.errorf(query, "%v", err)
+		}
+		var within, includes string
+		switch tok.op {
+		case "<=":
+			within = colors
+		case ">=":
+			includes = colors
+		case "=":
+			within, includes = colors, colors
+		case ":":
+			// Like Scryfall, c: means "at least these colors", while id: means "fits in a deck of
+			// this color identity".
+			if isColorKey(tok.key) {
+				includes = colors
+			} else {
+				within = colors
+			}
+		default:
+			return SearchFilters{}, tok.errorf(query, "unsupported operator %q for colors", tok.op)
+		}
+		if colors == "C" {
+			// Including "no colors" is meaningless, so c:c and c>=c mean colorless too.
+			within, includes = colors, ""
+		}
+		if isColorKey(tok.key) {
+			filters.Colors, filters.ColorsIncludes = within, includes
+		} else {
+			filters.ColorIdentity, filters.ColorIdentityIncludes = within, includes
+		}
+	case "t", "type":
+		if tok.op != ":" && tok.op != "=" {
+			return SearchFilters{}, tok.errorf(query, "types can only be matched with :")
+		}
+		filters.TypeLine = []string{tok.value}
+	case "o", "oracle":
+		if tok.op != ":" && tok.op != "=" {
+			return SearchFilters{}, tok.errorf(query, "rules text can only be matched with :")
+		}
+		filters.Text = []string{tok.value}
+	case "kw", "keyword":
+		if tok.op != ":" && tok.op != "=" {
+			return SearchFilters{}, tok.errorf(query, "keywords can only be matched with :")
+		}
+		filters.Keywords = []string{tok.value}
+	case "f", "format", "legal":
+		if tok.op != ":" && tok.op != "=" {
+			return SearchFilters{}, tok.errorf(query, "formats can only be matched with :")
+		}
+		filters.Formats = []string{strings.ToLower(tok.value)}
+	case "mv", "cmc", "manavalue", "pow", "power", "tou", "toughness":
+		c, err := tok.comparison()
+		if err != nil {
+			return SearchFilters{}, tok.errorf(query, "%v", err)
+		}
+		switch tok.key {
+		case "mv", "cmc", "manavalue":
+			filters.ManaValue = []Comparison{c}
+		case "pow", "power":
+			filters.Power = []Comparison{c}
+		default:
+			filters.Toughness = []Comparison{c}
+		}
+	case "is":
+		if tok.op != ":" {
+			return SearchFilters{}, tok.errorf(query, "is can only be used with :")
+		}
+		switch strings.ToLower(tok.value) {
+		case "reserved":
+			reserved := true
+			filters.Reserved = &reserved
+		default:
+			return SearchFilters{}, tok.errorf(query, "unsupported is: value %q", tok.value)
+		}
+	default:
+		return SearchFilters{}, tok.errorf(query, "unknown keyword %q", tok.key)
+	}
+	return filters, nil
+}
+
+func (tok queryToken) comparison() (Comparison, error) {
+	op := tok.op
+	switch op {
+	case ":":
+		op = "="
+	case "!=":
+		return Comparison{}, fmt.Errorf("unsupported operator %q", op)
+	}
+	return parseComparison(op + tok.value)
+}
+
+// isColorKey reports whether key filters on the colors of a card, rather than its color identity.
+func isColorKey(key string) bool {
+	return key == "c" || key == "color" || key == "colors"
+}
+
+// parseColorValue parses colors written as abbreviations (e.g. "gu") or a color name.
+func parseColorValue(s string) (string, error) {
+	if colors, ok := colorNames[strings.ToLower(s)]; ok {
+		return colors, nil
+	}
+	return parseColors(s)
+}
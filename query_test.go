@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	reserved, notReserved := true, false
+	for _, tc := range []struct {
+		query string
+		want  ParsedQuery
+	}{
+		{
+			query: "draw a card",
+			want:  ParsedQuery{Text: "draw a card"},
+		},
+		{
+			query: `c:g t:creature mv<=3 o:"draw a card" landfall`,
+			want: ParsedQuery{
+				Text: "landfall",
+				Filters: SearchFilters{
+					ColorsIncludes: "G",
+					TypeLine:       []string{"creature"},
+					ManaValue:      []Comparison{{Op: OpLte, Value: 3}},
+					Text:           []string{"draw a card"},
+				},
+			},
+		},
+		{
+			query: "id:ug id>=u c=r c<=blue",
+			want: ParsedQuery{
+				Filters: SearchFilters{
+					ColorIdentity:         "UG",
+					ColorIdentityIncludes: "U",
+					Colors:                "C",
+					ColorsIncludes:        "R",
+				},
+			},
+		},
+		{
+			query: "POW>2 tou:1 cmc=0 f:Modern legal:pauper kw:flying",
+			want: ParsedQuery{
+				Filters: SearchFilters{
+					Power:     []Comparison{{Op: OpGt, Value: 2}},
+					Toughness: []Comparison{{Op: OpEq, Value: 1}},
+					ManaValue: []Comparison{{Op: OpEq, Value: 0}},
+					Formats:   []string{"modern", "pauper"},
+					Keywords:  []string{"flying"},
+				},
+			},
+		},
+		{
+			query: "is:reserved",
+			want:  ParsedQuery{Filters: SearchFilters{Reserved: &reserved}},
+		},
+		{
+			query: `-is:reserved -t:creature -o:"draw a card" -`,
+			want: ParsedQuery{
+				Text: "-",
+				Filters: SearchFilters{
+					Reserved: &notReserved,
+					Exclude: []SearchFilters{
+						{TypeLine: []string{"creature"}},
+						{Text: []string{"draw a card"}},
+					},
+				},
+			},
+		},
+		{
+			query: `"fire // ice"`,
+			want:  ParsedQuery{Text: "fire // ice"},
+		},
+		{
+			// Lowercase "or", and words starting with "-" which aren't keyword terms, are text.
+			query: "destroy target artifact or enchantment",
+			want:  ParsedQuery{Text: "destroy target artifact or enchantment"},
+		},
+		{
+			query: "-1/-1 +1/+1 -landfall -t:elf",
+			want: ParsedQuery{
+				Text:    "-1/-1 +1/+1 -landfall",
+				Filters: SearchFilters{Exclude: []SearchFilters{{TypeLine: []string{"elf"}}}},
+			},
+		},
+		{
+			// Only known keywords are keyword terms, so card names with colons are free text.
+			query: "Circle of Protection: Red",
+			want:  ParsedQuery{Text: "Circle of Protection: Red"},
+		},
+		{
+			query: "Ajani: t:planeswalker",
+			want:  ParsedQuery{Text: "Ajani:", Filters: SearchFilters{TypeLine: []string{"planeswalker"}}},
+		},
+	} {
+		got, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("parsing %q: %v", tc.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parsing %q: got %+v, want %+v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		token string
+		pos   int
+	}{
+		{query: "t:creature mv<=three", token: "mv<=three", pos: 11},
+		{query: `o:"draw a card`, token: `o:"draw a card`, pos: 0},
+		{query: "landfall foo:bar", token: "foo:bar", pos: 9},
+		{query: "typ:creature", token: "typ:creature", pos: 0},
+		{query: "t:elf pwr>3", token: "pwr>3", pos: 6},
+		{query: "landfall -foo:bar", token: "-foo:bar", pos: 9},
+		{query: "c:gx", token: "c:gx", pos: 0},
+		{query: "t<creature", token: "t<creature", pos: 0},
+		{query: "mv!=3", token: "mv!=3", pos: 0},
+		{query: "elves OR goblins", token: "OR", pos: 6},
+		{query: "(t:elf)", token: "(t:elf)", pos: 0},
+		{query: "is:reserved -is:reserved", token: "-is:reserved", pos: 12},
+		{query: "is:funny", token: "is:funny", pos: 0},
+		{query: "t:", token: "t:", pos: 0},
+	} {
+		_, err := ParseQuery(tc.query)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("parsing %q: got %v, want a ParseError", tc.query, err)
+			continue
+		}
+		if perr.Token != tc.token || perr.Pos != tc.pos {
+			t.Errorf("parsing %q: error at %q (%d), want %q (%d)", tc.query, perr.Token, perr.Pos, tc.token, tc.pos)
+		}
+	}
+
+	_, err := ParseQuery("t:creature mv<=three")
+	want := "t:creature mv<=three\n           ^^^^^^^^^"
+	if perr := (*ParseError)(nil); !errors.As(err, &perr) || perr.Pointer() != want {
+		t.Errorf("got pointer %q, want %q", perr.Pointer(), want)
+	}
+}
+
+func TestParseQuerySearch(t *testing.T) {
+	fixture, _ := loadFixture(t)
+	backend := newMemoryBackend("")
+	index := &Index{Name: "cards", Namespace: "mtg_cards"}
+	if _, err := upsertSet(t.Context(), backend, index.Namespace, atomicEntries(fixture), nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{query: "c:g t:creature mv<=1", want: []string{"Llanowar Elves"}},
+		{query: "t:elf", want: []string{"Llanowar Elves"}},
+		{query: "t:legendary", want: nil},
+		{query: "id<=u -t:creature", want: []string{"Divination", "Mox Diamond", "Opt"}},
+		{query: `o:"draw a card"`, want: []string{"Fire // Ice", "Opt"}},
+		{query: `o:"draw a card" -o:scry`, want: []string{"Fire // Ice"}},
+		{query: "id>=ur", want: []string{"Fire // Ice"}},
+		{query: "c=u t:creature", want: []string{"Delver of Secrets // Insectile Aberration"}},
+		{query: "c:colorless", want: []string{"Mox Diamond"}},
+		{query: "-is:reserved t:artifact", want: nil},
+		{query: "landfall f:modern", want: []string{"Lotus Cobra"}},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			parsed, err := ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := index.Search(t.Context(), backend, SearchRequest{
+				Query:   parsed.Text,
+				Filters: parsed.Filters,
+				TopK:    10,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, result := range results {
+				got = append(got, result.Name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
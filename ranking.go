@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// RankingProfile sets how keyword search (and the keyword half of hybrid search) ranks cards: how
+// much the BM25 score of each full-text attribute counts, and how much popular cards are boosted.
+// There are built-in profiles, see rankingProfiles, and more can be defined in the config file
+// under "ranking", e.g.:
+//
+//	[ranking.rules]
+//	weights = { name = 1, text = 2, rulings = 2 }
+//	popularity_boost = 0.5
+type RankingProfile struct {
+	// Weights multiply the BM25 score of each of rankingAttributes. Attributes without a weight
+	// aren't matched against the query.
+	Weights map[string]float64 `json:"weights"`
+
+	// LangWeights multiply the BM25 score of the name, type and text of cards in the language of
+	// searches in other languages, see SearchRequest.Lang. If empty, the weights of name, type and
+	// text in Weights are used.
+	LangWeights map[string]float64 `json:"lang_weights,omitempty"`
+
+	// PopularityBoost multiplies the score of the most popular card by 1+PopularityBoost, and of
+	// less popular cards by less, see popularity. Cards without an EDHREC rank aren't boosted.
+	PopularityBoost float64 `json:"popularity_boost,omitempty"`
+}
+
+// rankingAttributes are the full-text attributes which a RankingProfile can weigh, in order.
+var rankingAttributes = []string{"name", "type", "text", "keywords", "rulings"}
+
+// langAttributes are the attributes which cards have in other languages, see Language.attr.
+var langAttributes = []string{"name", "type", "text"}
+
+// DefaultProfile is the name of the ranking profile used if none is chosen.
+const DefaultProfile = "default"
+
+// rankingProfiles are the built-in ranking profiles. Profiles of the same name in the config file
+// replace them.
+var rankingProfiles = map[string]RankingProfile{
+	// Names count double, as searches are usually for a card the user knows the name of. Foreign
+	// types count too, as they're often how players know cards in their language.
+	DefaultProfile: {
+		Weights:     map[string]float64{"name": 2, "text": 1},
+		LangWeights: map[string]float64{"name": 2, "type": 0.5, "text": 1},
+	},
+	// Rules searches are for what cards do, so every attribute about that counts.
+	"rules": {
+		Weights: map[string]float64{"name": 1, "type": 0.5, "text": 2, "keywords": 1, "rulings": 1},
+	},
+	// Popular is the default profile, but prefers cards which are played more, for deck building.
+	"popular": {
+		Weights:         map[string]float64{"name": 2, "text": 1},
+		LangWeights:     map[string]float64{"name": 2, "type": 0.5, "text": 1},
+		PopularityBoost: 1,
+	},
+}
+
+// configRankingProfiles are the ranking profiles defined in the config file, see loadConfig.
+var configRankingProfiles = make(map[string]RankingProfile)
+
+// LookupProfile returns the ranking profile with the given name, from the config file or else
+// built in. An empty name is DefaultProfile.
+func LookupProfile(name string) (RankingProfile, error) {
+	name = cmp.Or(name, DefaultProfile)
+	if profile, ok := configRankingProfiles[name]; ok {
+		return profile, nil
+	}
+	if profile, ok := rankingProfiles[name]; ok {
+		return profile, nil
+	}
+	return RankingProfile{}, fmt.Errorf(
+		"unknown ranking profile %q, must be one of %s",
+		name,
+		strings.Join(profileNames(), ", "),
+	)
+}
+
+// profileNames returns the name of every ranking profile, sorted.
+func profileNames() []string {
+	names := slices.Collect(maps.Keys(rankingProfiles))
+	for name := range configRankingProfiles {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Validate checks the profile only weighs rankingAttributes, and can search in every language.
+func (p RankingProfile) Validate() error {
+	check := func(weights map[string]float64, attrs []string) error {
+		for attr, weight := range weights {
+			if !slices.Contains(attrs, attr) {
+				return fmt.Errorf("can't weigh %q, must be one of %s", attr, strings.Join(attrs, ", "))
+			}
+			if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+				return fmt.Errorf("weight of %q must be a non-negative number, got %v", attr, weight)
+			}
+		}
+		return nil
+	}
+	if err := check(p.Weights, rankingAttributes); err != nil {
+		return err
+	}
+	if err := check(p.LangWeights, langAttributes); err != nil {
+		return err
+	}
+	if len(p.fields(nil)) == 0 {
+		return fmt.Errorf("must weigh at least one of %s", strings.Join(rankingAttributes, ", "))
+	}
+	if len(p.fields(&Language{})) == 0 {
+		return fmt.Errorf("must weigh at least one of %s, to search in other languages", strings.Join(langAttributes, ", "))
+	}
+	if p.PopularityBoost < 0 || math.IsNaN(p.PopularityBoost) || math.IsInf(p.PopularityBoost, 0) {
+		return fmt.Errorf("popularity boost must be a non-negative number, got %v", p.PopularityBoost)
+	}
+	return nil
+}
+
+// fields returns the attributes to rank by and their weights, in the order of rankingAttributes.
+// If lang is non-nil, they're the attributes of cards in that language, see Language.searchAttr.
+func (p RankingProfile) fields(lang *Language) []FieldWeight {
+	var fields []FieldWeight
+	for _, attr := range rankingAttributes {
+		weight := p.Weights[attr]
+		if lang != nil {
+			if !slices.Contains(langAttributes, attr) {
+				continue
+			}
+			if len(p.LangWeights) > 0 {
+				weight = p.LangWeights[attr]
+			}
+			attr = lang.searchAttr(attr)
+		}
+		if weight > 0 {
+			fields = append(fields, FieldWeight{Attribute: attr, Weight: weight})
+		}
+	}
+	return fields
+}
+
+// popularityOversample is how many more rows are asked for when boosting popular cards, as cards
+// just outside the top results may overtake those in it.
+const popularityOversample = 4
+
+// popularity is how popular a card is from its EDHREC rank: 1 for the most played card, decaying
+// with the order of magnitude of the rank, e.g. 0.25 for the 1000th card. Cards without a rank (0)
+// have no popularity.
+func popularity(rank float64) float64 {
+	if rank < 1 {
+		return 0
+	}
+	return 1 / (1 + math.Log10(rank))
+}
+
+// boostPopular multiplies the "$dist" (score) of each row by 1+boost*popularity of its
+// edhrec_rank, and re-sorts the rows by score. Vector similarities can be negative, and boosting
+// them would sink popular cards, so scores are shifted above the lowest first.
+func boostPopular(rows []Row, boost float64) {
+	var lowest float64
+	for _, row := range rows {
+		score, _ := row["$dist"].(float64)
+		lowest = min(lowest, score)
+	}
+	for _, row := range rows {
+		score, _ := row["$dist"].(float64)
+		rank, _ := row["edhrec_rank"].(float64)
+		row["$dist"] = (score-lowest)*(1+boost*popularity(rank)) + lowest
+	}
+	slices.SortStableFunc(rows, func(a, b Row) int {
+		return cmp.Compare(b["$dist"].(float64), a["$dist"].(float64))
+	})
+}
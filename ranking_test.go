@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRankingProfileFields(t *testing.T) {
+	de, err := lookupLanguage("de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		profile string
+		lang    *Language
+		want    []FieldWeight
+	}{
+		{DefaultProfile, nil, []FieldWeight{{"name", 2}, {"text", 1}}},
+		{DefaultProfile, de, []FieldWeight{{"name_de", 2}, {"type_de", 0.5}, {"text_de", 1}}},
+		{"rules", nil, []FieldWeight{{"name", 1}, {"type", 0.5}, {"text", 2}, {"keywords", 1}, {"rulings", 1}}},
+		{"rules", de, []FieldWeight{{"name_de", 1}, {"type_de", 0.5}, {"text_de", 2}}},
+	} {
+		profile, err := LookupProfile(tc.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := profile.fields(tc.lang); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("fields of %s profile (lang %v) = %v, want %v", tc.profile, tc.lang, got, tc.want)
+		}
+	}
+
+	for name, profile := range rankingProfiles {
+		if err := profile.Validate(); err != nil {
+			t.Errorf("built-in ranking profile %q is invalid: %v", name, err)
+		}
+	}
+	if _, err := LookupProfile("bogus"); err == nil {
+		t.Error("looking up an unknown ranking profile succeeded")
+	}
+}
+
+func TestRankingProfileValidate(t *testing.T) {
+	for name, profile := range map[string]RankingProfile{
+		"no weights":        {},
+		"unknown attribute": {Weights: map[string]float64{"flavor_text": 1}},
+		"negative weight":   {Weights: map[string]float64{"name": 1, "text": -1}},
+		"only rulings":      {Weights: map[string]float64{"rulings": 1}},
+		"foreign keywords":  {Weights: map[string]float64{"name": 1}, LangWeights: map[string]float64{"keywords": 1}},
+		"negative boost":    {Weights: map[string]float64{"name": 1}, PopularityBoost: -1},
+	} {
+		if err := profile.Validate(); err == nil {
+			t.Errorf("%s: validating %+v succeeded", name, profile)
+		}
+	}
+}
+
+func TestSearchRankingProfiles(t *testing.T) {
+	fixture, _ := loadFixture(t)
+	backend := newMemoryBackend("")
+	index := &Index{Name: "cards", Namespace: "mtg_cards"}
+	if _, err := upsertSet(t.Context(), backend, index.Namespace, atomicEntries(fixture), nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	search := func(query string, profile RankingProfile) []SearchResult {
+		t.Helper()
+		results, err := index.Search(t.Context(), backend, SearchRequest{Query: query, TopK: 3, Profile: &profile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	// "leave" is only in a ruling of Opt, which the default profile doesn't match.
+	if results := search("leave", rankingProfiles[DefaultProfile]); len(results) != 0 {
+		t.Errorf("default search for leave: got %v, want no results", results)
+	}
+	if results := search("leave", rankingProfiles["rules"]); len(results) != 1 || results[0].Name != "Opt" {
+		t.Errorf("rules search for leave: got %v, want Opt", results)
+	}
+
+	// Opt is the most popular card which draws, so a large enough boost ranks it first.
+	popular := RankingProfile{Weights: map[string]float64{"text": 1}, PopularityBoost: 100}
+	results := search("draw", popular)
+	if len(results) == 0 || results[0].Name != "Opt" {
+		t.Fatalf("popular search for draw: got %v, want Opt first", results)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Score > results[i-1].Score {
+			t.Errorf("result %d scores %v, more than the result before it (%v)", i, results[i].Score, results[i-1].Score)
+		}
+	}
+}
+
+func TestBoostPopular(t *testing.T) {
+	rows := []Row{
+		{"id": "obscure", "$dist": 1.0, "edhrec_rank": 10000.0},
+		{"id": "unranked", "$dist": 0.95},
+		{"id": "staple", "$dist": 0.9, "edhrec_rank": 1.0},
+	}
+	boostPopular(rows, 1)
+
+	var got []string
+	for _, row := range rows {
+		got = append(got, row["id"].(string))
+	}
+	// The staple doubles its score, the obscure card gains a fifth, and the unranked card nothing.
+	if want := []string{"staple", "obscure", "unranked"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if score := rows[0]["$dist"].(float64); score != 1.8 {
+		t.Errorf("boosted score of the staple = %v, want 1.8", score)
+	}
+
+	// Negative scores, e.g. vector similarities, are boosted up too.
+	rows = []Row{
+		{"id": "unranked", "$dist": 0.0},
+		{"id": "staple", "$dist": -0.1, "edhrec_rank": 1.0},
+		{"id": "obscure", "$dist": -0.3, "edhrec_rank": 10000.0},
+	}
+	boostPopular(rows, 1)
+	got = got[:0]
+	for _, row := range rows {
+		got = append(got, row["id"].(string))
+	}
+	if want := []string{"staple", "unranked", "obscure"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v with negative scores, want %v", got, want)
+	}
+	if score := rows[0]["$dist"].(float64); score <= -0.1 {
+		t.Errorf("boosted score of the staple = %v, want more than -0.1", score)
+	}
+}
+
+func TestConfigRankingProfiles(t *testing.T) {
+	keepSettings(t)
+	setFlag(t, flagConfig, writeConfig(t, "config.toml", `
+backend = "memory"
+
+[ranking.rules]
+weights = { text = 1, rulings = 3 }
+popularity_boost = 0.5
+
+[ranking.names]
+weights = { name = 1 }
+`))
+	if err := loadConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Profiles in the config file replace built-in ones of the same name.
+	rules, err := LookupProfile("rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.Weights["rulings"] != 3 || rules.PopularityBoost != 0.5 {
+		t.Errorf("rules profile = %+v, want the one in the config file", rules)
+	}
+	if _, err := LookupProfile("names"); err != nil {
+		t.Error(err)
+	}
+	if got, want := fmt.Sprint(profileNames()), "[default names popular rules]"; got != want {
+		t.Errorf("profile names = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// OutputFormat is how puffingmtg search writes its results.
+type OutputFormat string
+
+const (
+	// TableFormat is an aligned table with a header, for reading.
+	TableFormat OutputFormat = "table"
+
+	// JSONFormat is a JSON array of results, each an object of the fields.
+	JSONFormat OutputFormat = "json"
+
+	// JSONLFormat is a JSON object per result, one per line.
+	JSONLFormat OutputFormat = "jsonl"
+
+	// CSVFormat is CSV, with a header.
+	CSVFormat OutputFormat = "csv"
+)
+
+func (f OutputFormat) Valid() bool {
+	switch f {
+	case TableFormat, JSONFormat, JSONLFormat, CSVFormat:
+		return true
+	}
+	return false
+}
+
+// resultFields are the fields of search results which aren't attributes of faces. Any attribute of
+// the index is a field too.
+var resultFields = []string{"rank", "score", "name", "card_id", "printing_id", "layout"}
+
+// defaultFields are the fields written by puffingmtg search if none are chosen.
+var defaultFields = []string{"name", "mana_cost", "type", "score"}
+
+// defaultPrintingFields are the defaultFields of printing indexes.
+var defaultPrintingFields = []string{"name", "set_code", "number", "mana_cost", "type", "score"}
+
+// resultAttributes returns which of fields are attributes of faces, to include in search results.
+func resultAttributes(fields []string) []string {
+	var attrs []string
+	for _, field := range fields {
+		if !slices.Contains(resultFields, field) {
+			attrs = append(attrs, field)
+		}
+	}
+	return attrs
+}
+
+// faceValues are the values of an attribute which differs between the faces of a card, in order.
+type faceValues []any
+
+// fieldValue returns the value of a field of the search result ranked rank (from 1). Attributes
+// which are the same for every face of the card (e.g. edhrec_rank) are a single value, while
+// those which differ (e.g. text) are faceValues.
+func fieldValue(rank int, result SearchResult, field string) any {
+	switch field {
+	case "rank":
+		return rank
+	case "score":
+		return result.Score
+	case "name":
+		return result.Name
+	case "card_id":
+		return result.CardID
+	case "printing_id":
+		return result.PrintingID
+	case "layout":
+		return result.Layout
+	}
+	values := make(faceValues, len(result.Faces))
+	for i, face := range result.Faces {
+		values[i] = face[field]
+	}
+	for _, value := range values[1:] {
+		if !reflect.DeepEqual(value, values[0]) {
+			return values
+		}
+	}
+	return values[0]
+}
+
+// formatValue formats the value of a field as text, for tables and CSV. Lists are separated by
+// commas, and the values of faces by " // ", like the names of cards.
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case faceValues:
+		texts := make([]string, len(value))
+		for i, v := range value {
+			texts[i] = formatValue(v)
+		}
+		return strings.Join(texts, " // ")
+	case []any:
+		texts := make([]string, len(value))
+		for i, v := range value {
+			texts[i] = formatValue(v)
+		}
+		return strings.Join(texts, ", ")
+	case []string:
+		return strings.Join(value, ", ")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// resultRecord is a search result projected onto fields, which encodes to a JSON object with the
+// fields in order.
+type resultRecord struct {
+	fields []string
+	values []any
+}
+
+func newResultRecord(rank int, result SearchResult, fields []string) resultRecord {
+	values := make([]any, len(fields))
+	for i, field := range fields {
+		values[i] = fieldValue(rank, result, field)
+	}
+	return resultRecord{fields: fields, values: values}
+}
+
+func (r resultRecord) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Mana costs and rules text are more readable unescaped, e.g. "{G} & {U}".
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, field := range r.fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(field); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := enc.Encode(r.values[i]); err != nil {
+			return nil, fmt.Errorf("encoding field %q: %w", field, err)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeResults writes search results to w in the given format, projected onto fields.
+func writeResults(w io.Writer, format OutputFormat, fields []string, results []SearchResult) error {
+	records := make([]resultRecord, len(results))
+	for i, result := range results {
+		records[i] = newResultRecord(i+1, result, fields)
+	}
+
+	switch format {
+	case JSONFormat:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case JSONLFormat:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	case CSVFormat:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(fields); err != nil {
+			return err
+		}
+		for _, record := range records {
+			row := make([]string, len(fields))
+			for i, value := range record.values {
+				row[i] = formatValue(value)
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(fields, "\t")))
+		for _, record := range records {
+			row := make([]string, len(fields))
+			for i, value := range record.values {
+				// Scores are only compared, so a few decimals are enough to read.
+				if f, ok := value.(float64); ok {
+					value = math.Round(f*1e4) / 1e4
+				}
+				// Rules text has a line per ability, which would break the table.
+				row[i] = cmp.Or(strings.ReplaceAll(formatValue(value), "\n", " "), "-")
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+}
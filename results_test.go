@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var testResults = []SearchResult{
+	{
+		Name:   "Fire // Ice",
+		Layout: "split",
+		Score:  0.123456,
+		Faces: []Row{
+			{"face_name": "Fire", "mana_cost": "{1}{R}", "types": []any{"Instant"}, "edhrec_rank": 1200.0},
+			{"face_name": "Ice", "mana_cost": "{1}{U}", "types": []any{"Instant"}, "edhrec_rank": 1200.0},
+		},
+	},
+	{
+		Name:   "Lotus Cobra",
+		Layout: "normal",
+		Score:  0.05,
+		Faces: []Row{
+			{"mana_cost": "{1}{G}", "types": []any{"Creature"}, "text": "Landfall — Whenever a land you control enters,\nadd one mana of any color."},
+		},
+	},
+}
+
+func TestWriteResults(t *testing.T) {
+	fields := []string{"rank", "name", "mana_cost", "types", "edhrec_rank", "text"}
+
+	var out strings.Builder
+	if err := writeResults(&out, JSONLFormat, fields, testResults); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := `{"rank":1,"name":"Fire // Ice","mana_cost":["{1}{R}","{1}{U}"],"types":["Instant"],"edhrec_rank":1200,"text":null}`
+	if len(lines) != 2 || lines[0] != want {
+		t.Errorf("got jsonl:\n%s\nwant %d lines, the first:\n%s", out.String(), len(testResults), want)
+	}
+
+	out.Reset()
+	if err := writeResults(&out, JSONFormat, fields, testResults); err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]any
+	if err := json.Unmarshal([]byte(out.String()), &records); err != nil || len(records) != 2 {
+		t.Fatalf("got json %q (err %v), want an array of 2 results", out.String(), err)
+	}
+	if records[1]["mana_cost"] != "{1}{G}" || records[1]["rank"] != 2.0 {
+		t.Errorf("got json result %v, want Lotus Cobra ranked 2", records[1])
+	}
+
+	out.Reset()
+	if err := writeResults(&out, CSVFormat, fields, testResults); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("reading csv %q: %v", out.String(), err)
+	}
+	if len(rows) != 3 || !slices.Equal(rows[0], fields) {
+		t.Fatalf("got csv %q, want a header and 2 results", rows)
+	}
+	if want := []string{"1", "Fire // Ice", "{1}{R} // {1}{U}", "Instant", "1200", ""}; !slices.Equal(rows[1], want) {
+		t.Errorf("got csv row %q, want %q", rows[1], want)
+	}
+	if !strings.Contains(rows[2][5], "\n") {
+		t.Errorf("csv row %q lost the newlines of the text", rows[2])
+	}
+
+	out.Reset()
+	if err := writeResults(&out, TableFormat, []string{"name", "score", "text"}, testResults); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") {
+		t.Fatalf("got table:\n%s\nwant a header and 2 results", out.String())
+	}
+	if fields := strings.Fields(lines[1]); fields[len(fields)-2] != "0.1235" || fields[len(fields)-1] != "-" {
+		t.Errorf("got table row %q, want a rounded score and no text", lines[1])
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchTopK = 10
+	maxSearchTopK     = 100
+
+	// shutdownTimeout bounds how long in-flight requests are given to finish once the server
+	// has been asked to stop.
+	shutdownTimeout = 10 * time.Second
+)
+
+// server exposes an Index over HTTP. If it's serving an alias, the index may be swapped out between
+// (or during) requests, so each request loads it once and uses that throughout.
+type server struct {
+	backend Backend
+	live    *liveIndex
+}
+
+func newServer(backend Backend, live *liveIndex) http.Handler {
+	s := &server{backend: backend, live: live}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search", s.handleSearch)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	return mux
+}
+
+// searchResponse is the JSON body returned by GET /search.
+type searchResponse struct {
+	Index   string         `json:"index"`
+	Query   string         `json:"query"`
+	Mode    SearchMode     `json:"mode"`
+	Lang    string         `json:"lang,omitempty"`
+	Profile string         `json:"profile"`
+	Filters SearchFilters  `json:"filters"`
+	TookMs  int64          `json:"took_ms"`
+	Results []SearchResult `json:"results"`
+}
+
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	index := s.live.Load()
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	parsed, err := ParseQuery(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid query: %w", err))
+		return
+	}
+	params, err := filtersFromParams(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid filters: %w", err))
+		return
+	}
+	filters, err := parsed.Filters.And(params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid filters: %w", err))
+		return
+	}
+	if parsed.Text == "" && filters.Empty() {
+		writeError(w, http.StatusBadRequest, errors.New("missing query parameter q (or filters)"))
+		return
+	}
+
+	lang := r.URL.Query().Get("lang")
+	mode, err := index.SearchMode(SearchMode(r.URL.Query().Get("mode")), lang)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	topk := defaultSearchTopK
+	if k := r.URL.Query().Get("k"); k != "" {
+		parsed, err := strconv.Atoi(k)
+		if err != nil || parsed < 1 || parsed > maxSearchTopK {
+			writeError(
+				w,
+				http.StatusBadRequest,
+				fmt.Errorf("invalid k %q, must be an integer between 1 and %d", k, maxSearchTopK),
+			)
+			return
+		}
+		topk = parsed
+	}
+
+	var collapse bool
+	if c := r.URL.Query().Get("collapse"); c != "" {
+		if collapse, err = strconv.ParseBool(c); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid collapse %q, must be true or false", c))
+			return
+		}
+	}
+
+	profile, err := LookupProfile(r.URL.Query().Get("profile"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	start := time.Now()
+	results, err := index.Search(r.Context(), s.backend, SearchRequest{
+		Query:    parsed.Text,
+		Mode:     mode,
+		Lang:     lang,
+		Filters:  filters,
+		TopK:     topk,
+		Collapse: collapse,
+		Profile:  &profile,
+	})
+	if err != nil {
+		log.Printf("searching index %q for %q: %v", index.Name, query, err)
+		writeError(w, http.StatusBadGateway, errors.New("search failed"))
+		return
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+
+	writeJSON(w, http.StatusOK, searchResponse{
+		Index:   index.Name,
+		Query:   query,
+		Mode:    mode,
+		Lang:    lang,
+		Profile: cmp.Or(r.URL.Query().Get("profile"), DefaultProfile),
+		Filters: filters,
+		TookMs:  time.Since(start).Milliseconds(),
+		Results: results,
+	})
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := map[string]string{
+		"status": "ok",
+		"index":  s.live.Load().Name,
+	}
+	if s.live.alias != "" {
+		health["alias"] = s.live.alias
+	}
+	writeJSON(w, http.StatusOK, health)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// listenAndServe serves handler on addr until ctx is cancelled, then shuts the server down
+// gracefully, giving in-flight requests up to shutdownTimeout to complete.
+func listenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("listening on %q: %w", addr, err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down http server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down http server: %w", err)
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("listening on %q: %w", addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ulikunitz/xz"
+)
+
+// sourceExtensions are the file extensions mtgjson publishes sets with, in order of preference.
+var sourceExtensions = []string{"", ".gz", ".xz", ".bz2"}
+
+// setReader reads a set, counting the bytes read from its file (before decompression), so the
+// progress of a build can be reported as the set is streamed.
+type setReader struct {
+	io.Reader
+	io.Closer
+	file *countingReader
+	size int64 // of the file, or -1 if unknown
+}
+
+// Progress returns how many bytes of the set's file have been read, and the size of the file (or
+// -1 if unknown). It's safe to call while the set is being read.
+func (r *setReader) Progress() (read, size int64) {
+	return r.file.n.Load(), r.size
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// openLocalSet opens a set from a local mtgjson file, decompressing it according to its extension.
+// If source is a directory, it's searched for the file mtgjson would serve the set as (e.g.
+// StandardAtomic.json or StandardAtomic.json.xz).
+func openLocalSet(set Set, source string) (*setReader, error) {
+	fp, err := resolveSource(set, source)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, fmt.Errorf("opening source file %q: %w", fp, err)
+	}
+	size := int64(-1)
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	file := &countingReader{r: f}
+
+	var r io.Reader
+	switch {
+	case strings.HasSuffix(fp, ".json"):
+		r = file
+	case strings.HasSuffix(fp, ".json.gz"):
+		r, err = gzip.NewReader(file)
+	case strings.HasSuffix(fp, ".json.xz"):
+		r, err = xz.NewReader(file)
+	case strings.HasSuffix(fp, ".json.bz2"):
+		r = bzip2.NewReader(file)
+	default:
+		err = errors.New("unsupported file extension, must be one of .json, .json.gz, .json.xz, .json.bz2")
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading source file %q: %w", fp, err)
+	}
+
+	return &setReader{Reader: r, Closer: f, file: file, size: size}, nil
+}
+
+func resolveSource(set Set, source string) (string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", fmt.Errorf("checking source %q: %w", source, err)
+	}
+	if !info.IsDir() {
+		return source, nil
+	}
+
+	url, err := set.DownloadURL()
+	if err != nil {
+		return "", fmt.Errorf("getting download URL for set %q: %w", set, err)
+	}
+	base := filepath.Join(source, path.Base(url))
+	for _, ext := range sourceExtensions {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext, nil
+		}
+	}
+	return "", fmt.Errorf("no file for set %q found in directory %q (looked for %s)", set, source, path.Base(url))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+// loadSet reads a set into memory, as streamCards decodes it.
+func loadSet(ctx context.Context, set Set, source string) (*AtomicSet, string, error) {
+	r, err := openSet(ctx, set, source)
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	loaded := &AtomicSet{Data: make(map[string][]AtomicCard)}
+	meta, checksum, err := streamCards(r, set, func(name string, faces []AtomicCard) error {
+		if _, ok := loaded.Data[name]; ok {
+			return fmt.Errorf("card %q streamed twice", name)
+		}
+		loaded.Data[name] = faces
+		return nil
+	})
+	loaded.Meta = meta
+	return loaded, checksum, err
+}
+
+func TestLoadSetFromSource(t *testing.T) {
+	want, checksum := loadFixture(t)
+	data, err := os.ReadFile(fixtureSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeCompressed := func(name string, wrap func(io.Writer) (io.WriteCloser, error)) string {
+		fp := filepath.Join(dir, name)
+		f, err := os.Create(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		w, err := wrap(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+
+	plain := fixtureSet
+	gz := writeCompressed("set.json.gz", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	})
+	xzDir := filepath.Join(dir, "archive")
+	if err := os.Mkdir(xzDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeCompressed(filepath.Join("archive", "StandardAtomic.json.xz"), func(w io.Writer) (io.WriteCloser, error) {
+		return xz.NewWriter(w)
+	})
+
+	for _, source := range []string{plain, gz, xzDir} {
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			got, gotChecksum, err := loadSet(t.Context(), Standard, source)
+			if err != nil {
+				t.Fatalf("loading set from %q: %v", source, err)
+			}
+			if gotChecksum != checksum {
+				t.Errorf("checksum = %q, want %q (same as the uncompressed file)", gotChecksum, checksum)
+			}
+			if len(got.Data) != len(want.Data) || got.Meta.Version != want.Meta.Version {
+				t.Errorf("decoded %d cards (version %q), want %d (version %q)",
+					len(got.Data), got.Meta.Version, len(want.Data), want.Meta.Version)
+			}
+		})
+	}
+
+	if _, _, err := loadSet(t.Context(), Pauper, xzDir); err == nil {
+		t.Error("loading a set missing from the source directory succeeded")
+	}
+}
+
+func TestMtgSet(t *testing.T) {
+	for flag, want := range map[string]string{
+		"all":      "/AtomicCards.json",
+		"vintage":  "/VintageAtomic.json",
+		"legacy":   "/LegacyAtomic.json",
+		"pauper":   "/PauperAtomic.json",
+		"MH3":      "/MH3.json",
+		"mh3":      "/MH3.json",
+		"ALL":      "/ALL.json",
+		"CON_":     "/CON_.json",
+		"10e":      "/10E.json",
+		"":         "",
+		"mh3.json": "",
+		"modernn":  "",
+	} {
+		setFlag(t, flagSet, flag)
+		set, err := mtgSet()
+		if want == "" {
+			if err == nil {
+				t.Errorf("-set %q: got set %q, want an error", flag, set)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("-set %q: %v", flag, err)
+			continue
+		}
+		url, err := set.DownloadURL()
+		if err != nil {
+			t.Errorf("-set %q: getting download URL: %v", flag, err)
+		} else if url != mtgjsonBaseURL+want {
+			t.Errorf("-set %q: download URL = %q, want %q", flag, url, mtgjsonBaseURL+want)
+		}
+	}
+}
+
+func TestLoadSetCode(t *testing.T) {
+	serveFixtures(t)
+	set, _, err := loadSet(t.Context(), Set("TST"), "")
+	if err != nil {
+		t.Fatalf("loading set: %v", err)
+	}
+	if set.Meta.Version != "5.2.2+20261001" {
+		t.Errorf("version = %q, want 5.2.2+20261001", set.Meta.Version)
+	}
+
+	// The showcase printing of Lightning Bolt is folded into the first one.
+	if len(set.Data) != 3 {
+		t.Fatalf("decoded %d cards, want 3", len(set.Data))
+	}
+	bolt := set.Data["Lightning Bolt"]
+	if len(bolt) != 1 || *bolt[0].Identifiers.ScryfallId != "10000000-0000-4000-8000-000000000001" {
+		t.Errorf("Lightning Bolt = %+v, want only its first printing", bolt)
+	}
+	faces := set.Data["Wear // Tear"]
+	if len(faces) != 2 || *faces[0].FaceName != "Wear" || *faces[1].FaceName != "Tear" {
+		t.Errorf("Wear // Tear has faces %+v, want Wear and Tear", faces)
+	}
+
+	backend := newMemoryBackend("")
+	index := &Index{Name: "tst", Namespace: "mtg_tst"}
+	if _, err := upsertSet(t.Context(), backend, index.Namespace, atomicEntries(set), nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	results, err := index.Search(t.Context(), backend, SearchRequest{Query: "enchantment", TopK: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name != "Wear // Tear" || len(results[0].Faces) != 2 {
+		t.Errorf("search for enchantment: got %+v, want both faces of Wear // Tear", results)
+	}
+}
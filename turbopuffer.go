@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/turbopuffer/turbopuffer-go"
+	"github.com/turbopuffer/turbopuffer-go/option"
+)
+
+// turbopufferBackend is a Backend which stores namespaces in turbopuffer.
+type turbopufferBackend struct {
+	client *turbopuffer.Client
+}
+
+func newTurbopufferBackend(client *turbopuffer.Client) *turbopufferBackend {
+	return &turbopufferBackend{client: client}
+}
+
+func newTurbopufferClient() (*turbopuffer.Client, error) {
+	apiKey, err := tpufApiKey()
+	if err != nil {
+		return nil, fmt.Errorf("getting turbopuffer api key: %w", err)
+	}
+	// The SDK's own retries are disabled, as the uploader retries transient failures itself (see
+	// -upload-retries), and retries under both would multiply.
+	opts := []option.RequestOption{option.WithAPIKey(apiKey), option.WithMaxRetries(0)}
+	if *flagTpufBaseURL != "" {
+		opts = append(opts, option.WithBaseURL(*flagTpufBaseURL))
+	} else {
+		opts = append(opts, option.WithRegion(tpufRegion()))
+	}
+	client := turbopuffer.NewClient(opts...)
+	return &client, nil
+}
+
+func (b *turbopufferBackend) Write(ctx context.Context, namespace string, req WriteRequest) error {
+	rows := make([]turbopuffer.RowParam, 0, len(req.Upserts))
+	for _, row := range req.Upserts {
+		rows = append(rows, turbopuffer.RowParam(row))
+	}
+	params := turbopuffer.NamespaceWriteParams{
+		UpsertRows: rows,
+		Schema:     req.Schema,
+	}
+	if slices.ContainsFunc(req.Upserts, func(row Row) bool { return row["vector"] != nil }) {
+		params.DistanceMetric = turbopuffer.DistanceMetricCosineDistance
+	}
+	for _, id := range req.Deletes {
+		// Unlike delete_by_filter, which turbopuffer applies first, deletes are applied after
+		// upserts.
+		params.Deletes = append(params.Deletes, id)
+	}
+	ns := b.client.Namespace(namespace)
+	if _, err := ns.Write(ctx, params); err != nil {
+		return translateTurbopufferError(err)
+	}
+	return nil
+}
+
+func (b *turbopufferBackend) Query(
+	ctx context.Context,
+	namespace string,
+	req QueryRequest,
+) ([]Row, error) {
+	params := turbopuffer.NamespaceQueryParams{
+		RankBy: turbopuffer.NewRankByAttribute("id", "asc"),
+		TopK:   turbopuffer.Int(int64(req.TopK)),
+		IncludeAttributes: turbopuffer.IncludeAttributesParam{
+			StringArray: req.IncludeAttributes,
+		},
+	}
+	if req.Vector != nil {
+		params.RankBy = turbopuffer.NewRankByVector("vector", req.Vector)
+	} else if len(req.Fields) > 0 {
+		fields := make([]turbopuffer.RankByText, 0, len(req.Fields))
+		for _, field := range req.Fields {
+			fields = append(fields, turbopuffer.NewRankByTextProduct(
+				field.Weight,
+				turbopuffer.NewRankByTextBM25(field.Attribute, req.Text),
+			))
+		}
+		params.RankBy = turbopuffer.NewRankByTextSum(fields)
+	}
+	if req.Filters != nil {
+		filters, err := compileFilter(*req.Filters)
+		if err != nil {
+			return nil, err
+		}
+		params.Filters = filters
+	}
+	ns := b.client.Namespace(namespace)
+	resp, err := ns.Query(ctx, params)
+	if err != nil {
+		return nil, translateTurbopufferError(err)
+	}
+	rows := make([]Row, 0, len(resp.Rows))
+	for _, r := range resp.Rows {
+		row := make(Row, len(r))
+		for k, v := range r {
+			row[k] = v
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (b *turbopufferBackend) Metadata(
+	ctx context.Context,
+	namespace string,
+) (*NamespaceMetadata, error) {
+	ns := b.client.Namespace(namespace)
+	meta, err := ns.Metadata(ctx, turbopuffer.NamespaceMetadataParams{})
+	if err != nil {
+		return nil, translateTurbopufferError(err)
+	}
+	return &NamespaceMetadata{
+		CreatedAt:          meta.CreatedAt,
+		ApproxRowCount:     meta.ApproxRowCount,
+		ApproxLogicalBytes: meta.ApproxLogicalBytes,
+	}, nil
+}
+
+func (b *turbopufferBackend) DeleteAll(ctx context.Context, namespace string) error {
+	ns := b.client.Namespace(namespace)
+	if _, err := ns.DeleteAll(ctx, turbopuffer.NamespaceDeleteAllParams{}); err != nil {
+		return translateTurbopufferError(err)
+	}
+	return nil
+}
+
+func (b *turbopufferBackend) Namespaces(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	iter := b.client.NamespacesAutoPaging(ctx, turbopuffer.NamespacesParams{
+		Prefix: turbopuffer.String(prefix),
+	})
+	for iter.Next() {
+		names = append(names, iter.Current().ID)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, translateTurbopufferError(err)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// compileFilter converts a Filter into the equivalent turbopuffer filter expression.
+func compileFilter(f Filter) (turbopuffer.Filter, error) {
+	switch f.Op {
+	case OpAnd, OpOr, OpNot:
+		filters := make([]turbopuffer.Filter, 0, len(f.Filters))
+		for _, sub := range f.Filters {
+			compiled, err := compileFilter(sub)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, compiled)
+		}
+		switch f.Op {
+		case OpAnd:
+			return turbopuffer.NewFilterAnd(filters), nil
+		case OpOr:
+			return turbopuffer.NewFilterOr(filters), nil
+		default:
+			if len(filters) != 1 {
+				return nil, fmt.Errorf("Not filter expects exactly one filter, got %d", len(filters))
+			}
+			return turbopuffer.NewFilterNot(filters[0]), nil
+		}
+	case OpEq:
+		return turbopuffer.NewFilterEq(f.Attribute, f.Value), nil
+	case OpIn:
+		return turbopuffer.NewFilterIn(f.Attribute, f.Value), nil
+	case OpLt:
+		return turbopuffer.NewFilterLt(f.Attribute, f.Value), nil
+	case OpLte:
+		return turbopuffer.NewFilterLte(f.Attribute, f.Value), nil
+	case OpGt:
+		return turbopuffer.NewFilterGt(f.Attribute, f.Value), nil
+	case OpGte:
+		return turbopuffer.NewFilterGte(f.Attribute, f.Value), nil
+	case OpContains:
+		return turbopuffer.NewFilterContains(f.Attribute, f.Value), nil
+	case OpContainsAny:
+		return turbopuffer.NewFilterContainsAny(f.Attribute, f.Value), nil
+	case OpContainsAllTokens:
+		text, ok := f.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("ContainsAllTokens filter expects a string, got %T", f.Value)
+		}
+		return turbopuffer.NewFilterContainsAllTokens(f.Attribute, text), nil
+	default:
+		return nil, fmt.Errorf("unsupported filter operator %q", f.Op)
+	}
+}
+
+// translateTurbopufferError maps turbopuffer's "not found" errors onto ErrNamespaceNotFound, and
+// rate limiting and server errors onto ErrTransient, so that callers can handle them without
+// knowing which backend they're talking to.
+func translateTurbopufferError(err error) error {
+	var tpufError *turbopuffer.Error
+	if !errors.As(err, &tpufError) {
+		return err
+	}
+	switch code := tpufError.StatusCode; {
+	case code == http.StatusNotFound:
+		return fmt.Errorf("%w: %w", ErrNamespaceNotFound, err)
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= 500:
+		return fmt.Errorf("%w: %w", ErrTransient, err)
+	}
+	return err
+}
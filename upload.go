@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxWriteBytes is the largest write request turbopuffer accepts, in bytes of JSON.
+const maxWriteBytes = 256 << 20 // 256MB
+
+// maxBatchDeletes bounds how many rows a single write deletes.
+const maxBatchDeletes = 1 << 10
+
+// Writes which fail with ErrTransient are retried after a delay which doubles with each attempt,
+// starting at retryBaseDelay and capped at retryMaxDelay. Delays are jittered, so that concurrent
+// writes which were rate limited together don't all retry at once.
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// progressInterval is how often the progress of an upload is logged.
+var progressInterval = 10 * time.Second
+
+// sourceProgress reports how many bytes of a set have been read, and its size (or -1 if unknown),
+// see setReader.Progress. It's used to estimate how long an upload has left.
+type sourceProgress func() (read, size int64)
+
+// uploadHooks observe an upload as it goes. Any of them may be nil.
+type uploadHooks struct {
+	// progress reports how much of the set has been streamed, to log how long the upload has left.
+	progress sourceProgress
+
+	// checkpoint is called every checkpointInterval with the last batch confirmed written, and
+	// what the namespace may hold so far, to persist it. See BuildCheckpoint.
+	checkpoint func(batch int64, cards map[string]IndexedCard) error
+}
+
+// uploader writes rows to a namespace in batches as they're added. Batches are sized by the JSON
+// encoding of their rows, and up to -upload-concurrency of them are written at once, while the next
+// one is being built. Writes which fail with ErrTransient are retried with exponential backoff, and
+// any other failure stops the upload.
+type uploader struct {
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	backend    Backend
+	namespace  string
+	schema     Schema
+	embedder   Embedder
+	batchBytes int
+	retries    int
+
+	// batch is the batch being built.
+	batch uploadBatch
+
+	// Batches are numbered in the order they're built, from 1. next is the number of the batch
+	// being built, and confirmed the number of the last batch which has been written along with
+	// every batch before it. written holds the batches after confirmed which have been written.
+	next      int64
+	mu        sync.Mutex
+	confirmed int64
+	written   map[int64]bool
+
+	workers chan struct{} // holds a token for each write in flight
+	wg      sync.WaitGroup
+
+	start    time.Time
+	progress sourceProgress
+	done     chan struct{}
+	stopped  sync.Once
+
+	upserted atomic.Int64
+	deleted  atomic.Int64
+	batches  atomic.Int64
+}
+
+// uploadBatch is a batch of rows to upsert and row IDs to delete, written in a single request.
+type uploadBatch struct {
+	rows    []Row
+	sizes   []int // of each row's JSON
+	size    int   // of all rows' JSON
+	deletes []string
+}
+
+// newUploader starts an upload to a namespace, configured by the -upload-* flags. If embedder is
+// non-nil, every row is embedded before it's written. progress may be nil if the progress of the
+// source isn't known. The uploader must be finished with wait or abort.
+func newUploader(
+	ctx context.Context,
+	backend Backend,
+	namespace string,
+	schema Schema,
+	embedder Embedder,
+	progress sourceProgress,
+) (*uploader, error) {
+	concurrency, err := uploadConcurrency()
+	if err != nil {
+		return nil, err
+	}
+	batchBytes, err := uploadBatchBytes()
+	if err != nil {
+		return nil, err
+	}
+	retries, err := uploadRetries()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	u := &uploader{
+		ctx:        ctx,
+		cancel:     cancel,
+		backend:    backend,
+		namespace:  namespace,
+		schema:     schema,
+		embedder:   embedder,
+		batchBytes: batchBytes,
+		retries:    retries,
+		next:       1,
+		written:    make(map[int64]bool),
+		workers:    make(chan struct{}, concurrency),
+		start:      time.Now(),
+		progress:   progress,
+		done:       make(chan struct{}),
+	}
+	go u.report()
+	return u, nil
+}
+
+// upsert adds a row to the batch being built, writing the batch first if the row doesn't fit.
+// Returns the error which stopped the upload, if any.
+func (u *uploader) upsert(row Row) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("encoding row %v: %w", row["id"], err)
+	}
+	if len(u.batch.rows) > 0 && u.batch.size+len(data) > u.batchBytes {
+		if err := u.flush(); err != nil {
+			return err
+		}
+	}
+	u.batch.rows = append(u.batch.rows, row)
+	u.batch.sizes = append(u.batch.sizes, len(data))
+	u.batch.size += len(data)
+	return nil
+}
+
+// delete adds row IDs to delete to the batch being built, writing the batch once it holds
+// maxBatchDeletes of them. Returns the error which stopped the upload, if any.
+func (u *uploader) delete(ids ...string) error {
+	for _, id := range ids {
+		u.batch.deletes = append(u.batch.deletes, id)
+		if len(u.batch.deletes) >= maxBatchDeletes {
+			if err := u.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush hands the batch being built to a worker to write, waiting for one to be free.
+func (u *uploader) flush() error {
+	batch := u.batch
+	if len(batch.rows) == 0 && len(batch.deletes) == 0 {
+		return context.Cause(u.ctx)
+	}
+	u.batch = uploadBatch{}
+	seq := u.next
+	u.next += 1
+
+	select {
+	case u.workers <- struct{}{}:
+	case <-u.ctx.Done():
+		return context.Cause(u.ctx)
+	}
+	// A worker frees up when its write fails too, so check the upload wasn't stopped meanwhile.
+	if err := context.Cause(u.ctx); err != nil {
+		<-u.workers
+		return err
+	}
+	u.wg.Go(func() {
+		defer func() { <-u.workers }()
+		if err := u.write(batch); err != nil {
+			u.cancel(err)
+			return
+		}
+		u.confirm(seq)
+	})
+	return nil
+}
+
+// building returns the number of the batch being built. Rows added since the last flush will be
+// written as part of it.
+func (u *uploader) building() int64 {
+	return u.next
+}
+
+// confirm records that a batch has been written.
+func (u *uploader) confirm(seq int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.written[seq] = true
+	for u.written[u.confirmed+1] {
+		delete(u.written, u.confirmed+1)
+		u.confirmed += 1
+	}
+}
+
+// lastConfirmed returns the number of the last batch which has been written, along with every
+// batch before it, or 0 if there's none.
+func (u *uploader) lastConfirmed() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.confirmed
+}
+
+// wait writes the last batch and waits for every write to finish. Returns the error which stopped
+// the upload, if any.
+func (u *uploader) wait() error {
+	err := u.flush()
+	u.wg.Wait()
+	if err == nil {
+		err = context.Cause(u.ctx)
+	}
+	u.stop()
+	return err
+}
+
+// abort stops the upload because of err, waiting for the writes in flight to be interrupted. It's
+// a no-op once the upload has stopped.
+func (u *uploader) abort(err error) {
+	u.cancel(err)
+	u.wg.Wait()
+	u.stop()
+}
+
+func (u *uploader) stop() {
+	u.stopped.Do(func() {
+		u.cancel(nil)
+		close(u.done)
+	})
+}
+
+// write embeds the rows of a batch and writes it. Embedding grows the rows past the size they
+// were batched by, so a batch which outgrows what turbopuffer accepts is split.
+func (u *uploader) write(batch uploadBatch) error {
+	if u.embedder != nil && len(batch.rows) > 0 {
+		if err := embedRows(u.ctx, u.embedder, batch.rows); err != nil {
+			return err
+		}
+		for i, row := range batch.rows {
+			data, err := json.Marshal(row["vector"])
+			if err != nil {
+				return fmt.Errorf("encoding vector of row %v: %w", row["id"], err)
+			}
+			size := len(`,"vector":`) + len(data)
+			batch.sizes[i] += size
+			batch.size += size
+		}
+	}
+	return u.split(batch)
+}
+
+// split writes a batch, halving it until each half fits in a write request.
+func (u *uploader) split(batch uploadBatch) error {
+	if batch.size <= maxWriteBytes || len(batch.rows) < 2 {
+		return u.writeWithRetries(WriteRequest{Upserts: batch.rows, Deletes: batch.deletes, Schema: u.schema})
+	}
+	half := len(batch.rows) / 2
+	first := uploadBatch{rows: batch.rows[:half], sizes: batch.sizes[:half]}
+	for _, size := range first.sizes {
+		first.size += size
+	}
+	second := uploadBatch{
+		rows:    batch.rows[half:],
+		sizes:   batch.sizes[half:],
+		size:    batch.size - first.size,
+		deletes: batch.deletes,
+	}
+	if err := u.split(first); err != nil {
+		return err
+	}
+	return u.split(second)
+}
+
+// writeWithRetries writes a batch, retrying transient failures with exponential backoff.
+func (u *uploader) writeWithRetries(req WriteRequest) error {
+	for attempt := 0; ; attempt++ {
+		err := u.backend.Write(u.ctx, u.namespace, req)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrTransient) || attempt >= u.retries {
+			return fmt.Errorf("writing batch of %d rows (%d deletes): %w", len(req.Upserts), len(req.Deletes), err)
+		}
+		delay := retryDelay(attempt)
+		log.Printf(
+			"writing batch of %d rows failed (attempt %d of %d), retrying in %s: %v",
+			len(req.Upserts),
+			attempt+1,
+			u.retries+1,
+			delay.Round(time.Millisecond),
+			err,
+		)
+		select {
+		case <-time.After(delay):
+		case <-u.ctx.Done():
+			return context.Cause(u.ctx)
+		}
+	}
+	u.upserted.Add(int64(len(req.Upserts)))
+	u.deleted.Add(int64(len(req.Deletes)))
+	u.batches.Add(1)
+	return nil
+}
+
+// retryDelay returns how long to wait before retrying a write which failed attempt+1 times: a
+// random duration between half of and the full exponential backoff.
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		delay = min(retryBaseDelay<<attempt, retryMaxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// report logs the progress of the upload every progressInterval, until it's finished.
+func (u *uploader) report() {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.Print(u.status())
+		case <-u.done:
+			return
+		}
+	}
+}
+
+// status describes the progress of the upload, e.g. "uploaded 52000 rows (2600 rows/s), read 40%
+// of the set, about 30s left". How long is left is estimated from how much of the set has been
+// read, if its size is known.
+func (u *uploader) status() string {
+	elapsed := time.Since(u.start)
+	rows := u.upserted.Load()
+	status := fmt.Sprintf("uploaded %d rows (%.0f rows/s)", rows, float64(rows)/elapsed.Seconds())
+	if u.progress == nil {
+		return status
+	}
+	if read, size := u.progress(); read > 0 && size > 0 {
+		left := time.Duration(float64(elapsed) * float64(size-read) / float64(read))
+		status += fmt.Sprintf(
+			", read %.0f%% of the set, about %s left",
+			100*float64(read)/float64(size),
+			left.Round(time.Second),
+		)
+	}
+	return status
+}
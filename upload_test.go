@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyBackend fails the writes for which fail returns true with err, recording what it wrote.
+type flakyBackend struct {
+	Backend
+	err  error
+	fail func(attempt int) bool
+
+	mu       sync.Mutex
+	attempts int
+	sizes    []int    // of the JSON of the rows of each successful write
+	upserted []string // IDs of the rows successfully written
+
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+}
+
+func (b *flakyBackend) Write(ctx context.Context, namespace string, req WriteRequest) error {
+	n := b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+	for {
+		peak := b.maxInFlight.Load()
+		if n <= peak || b.maxInFlight.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	// Give concurrent writes a chance to overlap.
+	time.Sleep(5 * time.Millisecond)
+
+	b.mu.Lock()
+	attempt := b.attempts
+	b.attempts += 1
+	b.mu.Unlock()
+	if b.fail(attempt) {
+		return b.err
+	}
+
+	data, err := json.Marshal(req.Upserts)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.sizes = append(b.sizes, len(data))
+	for _, row := range req.Upserts {
+		b.upserted = append(b.upserted, row["id"].(string))
+	}
+	b.mu.Unlock()
+	return b.Backend.Write(ctx, namespace, req)
+}
+
+// uploadRows uploads n rows of roughly 100KB each through an uploader.
+func uploadRows(t *testing.T, backend Backend, n int) error {
+	t.Helper()
+	up, err := newUploader(t.Context(), backend, "mtg_upload", turbopufferSchema(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		row := Row{"id": fmt.Sprintf("row-%03d", i), "text": strings.Repeat("x", 100<<10)}
+		if err := up.upsert(row); err != nil {
+			up.abort(err)
+			return err
+		}
+	}
+	return up.wait()
+}
+
+func TestUploaderRetries(t *testing.T) {
+	setFlag(t, &retryBaseDelay, time.Millisecond)
+	setFlag(t, flagUploadBatchSize, 1)
+	setFlag(t, flagUploadConcurrency, 3)
+
+	// Every other write is rate limited.
+	memory := newMemoryBackend("")
+	backend := &flakyBackend{
+		Backend: memory,
+		err:     fmt.Errorf("%w: 429 too many requests", ErrTransient),
+		fail:    func(attempt int) bool { return attempt%2 == 0 },
+	}
+	if err := uploadRows(t, backend, 40); err != nil {
+		t.Fatalf("uploading with transient errors: %v", err)
+	}
+	meta, err := memory.Metadata(t.Context(), "mtg_upload")
+	if err != nil || meta.ApproxRowCount != 40 {
+		t.Fatalf("uploaded %v rows (err %v), want 40", meta, err)
+	}
+	if len(backend.sizes) < 4 {
+		t.Errorf("uploaded 4MB in %d batches, want at least 4 of at most 1MB", len(backend.sizes))
+	}
+	for _, size := range backend.sizes {
+		if size > 1<<20 {
+			t.Errorf("wrote a batch of %d bytes, want at most 1MB", size)
+		}
+	}
+	if peak := backend.maxInFlight.Load(); peak < 2 || peak > 3 {
+		t.Errorf("%d writes were in flight at once, want 2 or 3", peak)
+	}
+}
+
+func TestUploaderGivesUp(t *testing.T) {
+	setFlag(t, &retryBaseDelay, time.Millisecond)
+	setFlag(t, flagUploadRetries, 2)
+
+	// Transient errors are retried until the retries run out.
+	backend := &flakyBackend{
+		Backend: newMemoryBackend(""),
+		err:     fmt.Errorf("%w: 503 service unavailable", ErrTransient),
+		fail:    func(int) bool { return true },
+	}
+	if err := uploadRows(t, backend, 1); !errors.Is(err, ErrTransient) {
+		t.Errorf("uploading to a failing backend: got %v, want ErrTransient", err)
+	}
+	if backend.attempts != 3 {
+		t.Errorf("wrote %d times, want 3 (2 retries)", backend.attempts)
+	}
+
+	// Other errors aren't retried, and stop the upload.
+	errDenied := errors.New("401 unauthorized")
+	backend = &flakyBackend{
+		Backend: newMemoryBackend(""),
+		err:     errDenied,
+		fail:    func(int) bool { return true },
+	}
+	if err := uploadRows(t, backend, 40); !errors.Is(err, errDenied) {
+		t.Errorf("uploading without access: got %v, want %v", err, errDenied)
+	}
+	if backend.attempts > *flagUploadConcurrency {
+		t.Errorf("wrote %d times after a permanent error, want no more than one per worker", backend.attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt, want := range []time.Duration{
+		retryBaseDelay,
+		2 * retryBaseDelay,
+		4 * retryBaseDelay,
+	} {
+		if got := retryDelay(attempt); got < want/2 || got > want {
+			t.Errorf("retryDelay(%d) = %s, want between %s and %s", attempt, got, want/2, want)
+		}
+	}
+	if got := retryDelay(100); got < retryMaxDelay/2 || got > retryMaxDelay {
+		t.Errorf("retryDelay(100) = %s, want at most %s", got, retryMaxDelay)
+	}
+}